@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sausheong/culture_sim/culture"
+)
+
+// a checkpoint of a simulation at the end of a tick, with the parameters it
+// runs with and the totals kept by the simulation loop, from which the
+// simulation can be restored to continue exactly as if it had never stopped
+type checkpoint struct {
+	Config      map[string]interface{} `json:"config"`
+	Tick        int                    `json:"tick"`
+	Exchanges   int                    `json:"exchanges"`
+	Media       int                    `json:"media"`
+	Fixation    int                    `json:"fixation"`
+	Monoculture int                    `json:"monoculture"`
+	State       culture.State          `json:"state"`
+}
+
+// save the checkpoint as JSON. It is written to a temporary file first, so
+// that the last checkpoint survives if the simulation is stopped while saving
+func saveCheckpoint(path string, cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// load a checkpoint saved by saveCheckpoint
+func loadCheckpoint(path string) (cp checkpoint, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	// a checkpoint saved before monocultures were detected has not reached one
+	cp.Monoculture = -1
+	// the parameters keep their numbers as they were written, as float64s
+	// the seeds above 2^53 would be rounded to another seed
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&cp); err != nil {
+		err = fmt.Errorf("cannot read checkpoint %s: %s", path, err)
+	}
+	return
+}
+
+// check that the restored simulation runs with the seed saved in its
+// checkpoint. Its random number generators are restored by drawing the same
+// numbers again from the seed, so with any other seed it wouldn't continue
+// as it would have
+func checkRestoredSeed(cp checkpoint, path string) error {
+	saved, ok := cp.Config["seed"]
+	if !ok {
+		return nil
+	}
+	if restored := strconv.FormatInt(*seed, 10); fmt.Sprint(saved) != restored {
+		return fmt.Errorf("checkpoint %s has seed %v, but was restored with seed %s", path, saved, restored)
+	}
+	return nil
+}
+
+// open the JSON lines of a restored simulation to append to, dropping the
+// lines of the ticks after its checkpoint that the simulation runs again
+func reopenJSONL(path string, tick int) (*os.File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var kept bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		var line struct {
+			Tick int `json:"tick"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &line) != nil || line.Tick > tick {
+			break
+		}
+		kept.Write(scanner.Bytes())
+		kept.WriteByte('\n')
+	}
+	if err := os.WriteFile(path, kept.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/sausheong/culture_sim/culture"
+)
+
+func TestCheckpointKeepsSeed(t *testing.T) {
+	useTestFlags(t)
+	// a seed from the clock, which a float64 can't hold exactly
+	const clockSeed int64 = 1791961309735626206
+	*seed = clockSeed
+	path := filepath.Join(t.TempDir(), "run.checkpoint.json")
+	if err := saveCheckpoint(path, checkpoint{Config: configParams(), Tick: 9, State: culture.State{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	*seed = 0
+	cp, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyParams(cp.Config, path); err != nil {
+		t.Fatal(err)
+	}
+	if *seed != clockSeed {
+		t.Errorf("seed is %d after restoring the checkpoint, want %d", *seed, clockSeed)
+	}
+	if err := checkRestoredSeed(cp, path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckpointWrongSeed(t *testing.T) {
+	useTestFlags(t)
+	*seed = 12345
+	cp := checkpoint{Config: map[string]interface{}{"seed": json.Number("12346")}}
+	if err := checkRestoredSeed(cp, "test"); err == nil {
+		t.Error("restoring a checkpoint with another seed succeeded")
+	}
+}
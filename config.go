@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// apply the simulation parameters in a JSON config file, an object mapping
+// flag names to their values such as {"n": 100, "w": 36, "wrap": true}.
+// Flags set explicitly on the command line override the values in the file
+func applyConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	params, err := decodeParams(data)
+	if err != nil {
+		return fmt.Errorf("cannot read config %s: %s", path, err)
+	}
+	return applyParams(params, path)
+}
+
+// decode the JSON object of the simulation parameters, keeping the numbers as
+// they were written. As float64s the seeds above 2^53 would be rounded to
+// another seed
+func decodeParams(data []byte) (params map[string]interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&params)
+	return
+}
+
+// apply the simulation parameters mapping flag names to their values, read
+// from the file at path, unless the flags are set explicitly on the command line
+func applyParams(params map[string]interface{}, path string) error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for name, value := range params {
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown parameter %q in config %s", name, path)
+		}
+		if explicit[name] {
+			continue
+		}
+		var s string
+		switch v := value.(type) {
+		case json.Number:
+			s = v.String()
+		case bool:
+			s = strconv.FormatBool(v)
+		case string:
+			s = v
+		default:
+			return fmt.Errorf("parameter %q in config %s must be a number, boolean or string", name, path)
+		}
+		if err := flag.Set(name, s); err != nil {
+			return fmt.Errorf("parameter %q in config %s: %s", name, path, err)
+		}
+	}
+	return nil
+}
+
+// the effective value of every simulation parameter, mapping flag names to
+// their values. The flags naming the files a simulation is started from or
+// records to are left out
+func configParams() map[string]interface{} {
+	params := make(map[string]interface{})
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name != "config" && f.Name != "restore" && f.Name != "record" && f.Name != "replay" {
+			params[f.Name] = f.Value.(flag.Getter).Get()
+		}
+	})
+	return params
+}
+
+// save the effective value of every simulation parameter as a JSON config
+// file, which can be passed back with -config to rerun the simulation
+func saveConfig(path string) error {
+	data, err := json.MarshalIndent(configParams(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// write the provenance of a data file as # key=value lines at its start: the
+// effective value of every simulation parameter, when the simulation started
+// and the git revision it was built from if known. CSV readers skip the lines
+// as comments, such as pandas with comment="#"
+func writeMetadata(w io.Writer, started time.Time) error {
+	var err error
+	write := func(key string, value interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, "# %s=%v\n", key, value)
+		}
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name != "config" {
+			write(f.Name, f.Value.(flag.Getter).Get())
+		}
+	})
+	write("started", started.Format(time.RFC3339))
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" || setting.Key == "vcs.modified" {
+				write(setting.Key, setting.Value)
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigKeepsSeed(t *testing.T) {
+	useTestFlags(t)
+	// a seed from the clock, which a float64 can't hold exactly
+	const clockSeed int64 = 1791961309735626206
+	*seed, *numTicks = clockSeed, 50
+	path := filepath.Join(t.TempDir(), "run.config.json")
+	if err := saveConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	*seed, *numTicks = 0, 0
+	if err := applyConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if *seed != clockSeed {
+		t.Errorf("seed is %d after applying the config, want %d", *seed, clockSeed)
+	}
+	if *numTicks != 50 {
+		t.Errorf("ticks are %d after applying the config, want 50", *numTicks)
+	}
+}
+
+func TestConfigFlagsOverride(t *testing.T) {
+	useTestFlags(t)
+	if err := flag.CommandLine.Parse([]string{"-seed", "7"}); err != nil {
+		t.Fatal(err)
+	}
+	params, err := decodeParams([]byte(`{"seed": 9, "t": 30}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyParams(params, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if *seed != 7 || *numTicks != 30 {
+		t.Errorf("seed %d and ticks %d, want the seed 7 from the command line and the ticks 30 from the config", *seed, *numTicks)
+	}
+}
+
+func TestConfigUnknownParameter(t *testing.T) {
+	useTestFlags(t)
+	params, err := decodeParams([]byte(`{"no-such-flag": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyParams(params, "test"); err == nil {
+		t.Error("applying an unknown parameter succeeded")
+	}
+}
@@ -0,0 +1,172 @@
+package culture
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// a source of random numbers that counts the numbers drawn from it. The state
+// of a generator can't be saved, but it can be brought back by drawing as many
+// numbers from a new source with the same seed
+type countingSource struct {
+	src   rand.Source64
+	draws uint64
+}
+
+// create a counting source with the seed
+func newCountingSource(seed int64) *countingSource {
+	return &countingSource{src: rand.NewSource(seed).(rand.Source64)}
+}
+
+func (c *countingSource) Int63() int64 {
+	c.draws++
+	return c.src.Int63()
+}
+
+func (c *countingSource) Uint64() uint64 {
+	c.draws++
+	return c.src.Uint64()
+}
+
+func (c *countingSource) Seed(seed int64) {
+	c.src.Seed(seed)
+	c.draws = 0
+}
+
+// draw numbers until as many have been drawn as the count
+func (c *countingSource) skip(count uint64) {
+	for c.draws < count {
+		c.Int63()
+	}
+}
+
+// seed the random number generator of the simulation, and those of the
+// workers from it
+func (s *Sim) seedRngs() {
+	src := newCountingSource(s.seed)
+	s.rng = rand.New(src)
+	s.sources = []*countingSource{src}
+	s.workerRngs = nil
+	if s.Workers > 1 {
+		for w := 0; w < s.Workers; w++ {
+			src := newCountingSource(s.rng.Int63())
+			s.workerRngs = append(s.workerRngs, rand.New(src))
+			s.sources = append(s.sources, src)
+		}
+	}
+}
+
+// State is the state of a simulation between ticks, everything a simulation
+// created with the same parameters and seed needs to continue from it exactly
+// as if it had never stopped
+type State struct {
+	Cells      []Cell
+	MetricData [][]string
+	Draws      []uint64 // numbers drawn from the random number generator of the simulation, then of every worker
+	Cultures   [][]int  // traits of the wide cultures, by culture integer, nil for packed cultures
+
+	Exchanges      int
+	MediaExchanges int
+	Colonies       int
+	Drifts         int
+	Migrations     int
+	Turnovers      int
+}
+
+// State is a copy of the current state of the simulation
+func (s *Sim) State() State {
+	st := State{
+		Cells:          make([]Cell, len(s.Cells)),
+		MetricData:     make([][]string, len(s.MetricData)),
+		Exchanges:      s.Exchanges,
+		MediaExchanges: s.MediaExchanges,
+		Colonies:       s.Colonies,
+		Drifts:         s.Drifts,
+		Migrations:     s.Migrations,
+		Turnovers:      s.Turnovers,
+	}
+	copy(st.Cells, s.Cells)
+	for n := range st.Cells {
+		st.Cells[n].Opinions = append([]float64(nil), s.Cells[n].Opinions...)
+	}
+	for i := range s.MetricData {
+		st.MetricData[i] = append([]string(nil), s.MetricData[i]...)
+	}
+	for _, src := range s.sources {
+		st.Draws = append(st.Draws, src.draws)
+	}
+	if s.wide != nil {
+		st.Cultures = s.wide.all()
+	}
+	return st
+}
+
+// Restore continues the simulation from a state, which must come from a
+// simulation created with the same parameters and seed and logging the same
+// metrics. The random number generators are brought back to the state by
+// drawing the same numbers again, which takes longer the longer the
+// simulation has run
+func (s *Sim) Restore(st State) error {
+	if len(st.Cells) != s.Width*s.Height {
+		return fmt.Errorf("state has %d cells, not the %d cells of a %d by %d grid", len(st.Cells), s.Width*s.Height, s.Width, s.Height)
+	}
+	if len(st.MetricData) != len(s.Metrics) {
+		return fmt.Errorf("state logs %d metrics, not the %d selected metrics", len(st.MetricData), len(s.Metrics))
+	}
+	for i, m := range s.Metrics {
+		if len(st.MetricData[i]) == 0 || st.MetricData[i][0] != m.Name() {
+			return fmt.Errorf("state doesn't log the metric %q in position %d", m.Name(), i)
+		}
+	}
+	if len(st.Draws) != len(s.sources) {
+		return fmt.Errorf("state has %d random number generators, not %d", len(st.Draws), len(s.sources))
+	}
+	var wide *cultureTable
+	if s.wide != nil {
+		wide = newCultureTable()
+		for c, traits := range st.Cultures {
+			if err := s.checkTraits(traits); err != nil {
+				return fmt.Errorf("state has invalid culture %d: %s", c, err)
+			}
+			if wide.culture(traits) != c {
+				return fmt.Errorf("state has culture %d twice", c)
+			}
+		}
+		for n, cell := range st.Cells {
+			if cell.Culture != Empty && (cell.Culture < 0 || cell.Culture >= wide.len()) {
+				return fmt.Errorf("state has invalid culture %d in cell %d", cell.Culture, n)
+			}
+		}
+	}
+
+	if wide != nil {
+		s.wide = wide
+		if s.MediaCulture != nil {
+			s.media = wide.culture(s.MediaCulture)
+		}
+	}
+	s.Cells = make([]Cell, len(st.Cells))
+	copy(s.Cells, st.Cells)
+	for n := range s.Cells {
+		if len(s.Cells[n].Opinions) == 0 {
+			s.Cells[n].Opinions = nil
+		}
+		// the cells are drawn at the current cell size
+		s.Cells[n].X, s.Cells[n].Y = s.cellPosition(n)
+		s.Cells[n].R = CELLSIZE
+	}
+	if s.Workers > 1 {
+		s.cellLocks = make([]sync.Mutex, len(s.Cells))
+	}
+	s.buildNeighbourTable()
+	s.MetricData = st.MetricData
+	s.Exchanges, s.MediaExchanges, s.Colonies = st.Exchanges, st.MediaExchanges, st.Colonies
+	s.Drifts, s.Migrations, s.Turnovers = st.Drifts, st.Migrations, st.Turnovers
+
+	s.seedRngs()
+	for i, src := range s.sources {
+		src.skip(st.Draws[i])
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package culture
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRestoreContinuesExactly(t *testing.T) {
+	params := testParams(12)
+	params.Drift = 0.01
+	const seed int64 = 1791961309735626206
+	const metrics = "distance,unique,entropy,drift"
+
+	uninterrupted := newTestSim(t, params, seed, metrics)
+	runTicks(t, uninterrupted, 40)
+	want := cultures(uninterrupted)
+
+	stopped := newTestSim(t, params, seed, metrics)
+	runTicks(t, stopped, 25)
+	state := stopped.State()
+
+	restored, err := NewSim(params, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.SelectMetrics(metrics); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Restore(state); err != nil {
+		t.Fatal(err)
+	}
+	runTicks(t, restored, 15)
+	if !sameCultures(cultures(restored), want) {
+		t.Error("the restored simulation ended with another grid than the uninterrupted one")
+	}
+	if fmt.Sprint(restored.MetricData) != fmt.Sprint(uninterrupted.MetricData) {
+		t.Error("the restored simulation logged other metrics than the uninterrupted one")
+	}
+}
+
+func TestRestoreWideCultures(t *testing.T) {
+	params := testParams(10)
+	params.Features, params.Traits = 10, 100
+	const seed int64 = 5
+
+	uninterrupted := newTestSim(t, params, seed, "unique")
+	runTicks(t, uninterrupted, 30)
+
+	stopped := newTestSim(t, params, seed, "unique")
+	runTicks(t, stopped, 20)
+	restored, err := NewSim(params, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.SelectMetrics("unique"); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Restore(stopped.State()); err != nil {
+		t.Fatal(err)
+	}
+	runTicks(t, restored, 10)
+	for n := range restored.Cells {
+		a, b := restored.FormatCulture(restored.Cells[n].Culture), uninterrupted.FormatCulture(uninterrupted.Cells[n].Culture)
+		if a != b {
+			t.Fatalf("cell %d of the restored simulation has the culture %s, not %s", n, a, b)
+		}
+	}
+	if fmt.Sprint(restored.MetricData) != fmt.Sprint(uninterrupted.MetricData) {
+		t.Error("the restored simulation logged other metrics than the uninterrupted one")
+	}
+}
+
+func TestRestoreOtherGrid(t *testing.T) {
+	s := newTestSim(t, testParams(6), 1, "unique")
+	other := newTestSim(t, testParams(8), 1, "unique")
+	if err := other.Restore(s.State()); err == nil {
+		t.Error("restoring the state of a 6 by 6 grid into an 8 by 8 grid succeeded")
+	}
+}
@@ -0,0 +1,59 @@
+package culture
+
+import "math"
+
+// SpatialCorrelation is the probability that 2 populated cells a distance r
+// apart along a row or a column share the same culture, for every distance r
+// up to maxR, with index r of the result for distance r. Pairs that would be
+// beyond the edges of the grid are only counted if the grid wraps around. The
+// probability is 0 for distances without any populated pair
+func (s *Sim) SpatialCorrelation(maxR int) []float64 {
+	correlation := make([]float64, maxR+1)
+	for r := 0; r <= maxR; r++ {
+		var pairs, same int
+		for n, cell := range s.Cells {
+			if cell.getRGB() == Empty {
+				continue
+			}
+			x, y := n/s.Height, n%s.Height
+			for _, d := range [][2]int{{r, 0}, {0, r}} {
+				cx, cy, ok := s.wrapCell(x+d[0], y+d[1])
+				if !ok {
+					continue
+				}
+				other := s.Cells[s.CellIndex(cx, cy)].getRGB()
+				if other == Empty {
+					continue
+				}
+				pairs++
+				if other == cell.getRGB() {
+					same++
+				}
+			}
+		}
+		if pairs > 0 {
+			correlation[r] = float64(same) / float64(pairs)
+		}
+	}
+	return correlation
+}
+
+// CorrelationLength is the distance over which cultures stay correlated, at
+// which the spatial correlation falls below 1/e, interpolated between the
+// distances on either side of it. The distances go up to half the side of the
+// grid, which is the correlation length if the correlation never falls that
+// low, and it is 0 for an empty grid. It grows as the cultural domains coarsen
+func (s *Sim) CorrelationLength() float64 {
+	maxR := s.side() / 2
+	correlation := s.SpatialCorrelation(maxR)
+	if correlation[0] == 0 {
+		return 0
+	}
+	threshold := 1 / math.E
+	for r := 1; r <= maxR; r++ {
+		if correlation[r] < threshold {
+			return float64(r-1) + (correlation[r-1]-threshold)/(correlation[r-1]-correlation[r])
+		}
+	}
+	return float64(maxR)
+}
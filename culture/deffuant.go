@@ -0,0 +1,87 @@
+package culture
+
+import "math"
+
+// distance between opinions below which they are treated as the same, since
+// opinions only approach each other and may never be exactly equal
+const agreement = 1e-9
+
+// the opinions of the features of a culture, each in the middle of the range
+// of opinions that round to its trait
+func (s *Sim) cultureOpinions(c int) []float64 {
+	opinions := make([]float64, s.Features)
+	for i := range opinions {
+		opinions[i] = (float64(s.Extract(c, uint(i))) + 0.5) / float64(s.Traits)
+	}
+	return opinions
+}
+
+// the culture the opinions round to, the trait of every feature being the
+// range of opinions its opinion falls in
+func (s *Sim) opinionCulture(opinions []float64) int {
+	traits := make([]int, len(opinions))
+	for i, o := range opinions {
+		if traits[i] = int(o * float64(s.Traits)); traits[i] >= s.Traits {
+			traits[i] = s.Traits - 1
+		}
+	}
+	return s.cultureOf(traits)
+}
+
+// move the opinions of the cell at index n to a new culture i, only for the
+// features whose trait changed so that the opinions of the others are kept
+func (s *Sim) syncOpinions(n, i int) {
+	cell := &s.Cells[n]
+	switch {
+	case i == Empty:
+		cell.Opinions = nil
+	case cell.Culture == Empty || cell.Opinions == nil:
+		cell.Opinions = s.cultureOpinions(i)
+	default:
+		opinions := s.cultureOpinions(i)
+		for f := range opinions {
+			if s.Extract(i, uint(f)) != s.Extract(cell.Culture, uint(f)) {
+				cell.Opinions[f] = opinions[f]
+			}
+		}
+	}
+}
+
+// OpinionDistance is the average distance between the opinions of all
+// features of the cells at indices a1 and a2 in the deffuant model, between 0
+// for the same opinions and 1 for opposite opinions
+func (s *Sim) OpinionDistance(a1, a2 int) float64 {
+	var d float64
+	o1, o2 := s.Cells[a1].Opinions, s.Cells[a2].Opinions
+	for i := range o1 {
+		d += math.Abs(o1[i] - o2[i])
+	}
+	return d / float64(s.Features)
+}
+
+// interaction between the cell at index r and its neighbour in the deffuant
+// model. If their opinions are closer than the confidence, the opinions of
+// both move the convergence rate of the way towards their average, less the
+// further the more stubborn the cell is, and their cultures are rounded from
+// the new opinions. Returns true if the opinions moved
+func (s *Sim) converge(r, neighbour int) bool {
+	d := s.OpinionDistance(r, neighbour)
+	if d < agreement || d >= s.Confidence {
+		return false
+	}
+	o1, o2 := s.Cells[r].Opinions, s.Cells[neighbour].Opinions
+	rate1 := s.ConvergenceRate * (1 - s.Cells[r].Stubbornness)
+	rate2 := s.ConvergenceRate * (1 - s.Cells[neighbour].Stubbornness)
+	for i := range o1 {
+		average := (o1[i] + o2[i]) / 2
+		o1[i] += rate1 * (average - o1[i])
+		o2[i] += rate2 * (average - o2[i])
+	}
+	for _, n := range []int{r, neighbour} {
+		if c := s.opinionCulture(s.Cells[n].Opinions); c != s.Cells[n].Culture {
+			s.Cells[n].Culture = c
+			s.Cells[n].Changes++
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package culture
+
+// DomainSizes are the sizes of the connected domains of identical culture on
+// the grid, in the order of their labels. Empty cells are not part of any
+// domain
+func (s *Sim) DomainSizes() []int {
+	_, sizes := s.domains()
+	return sizes
+}
+
+// domains of the grid labelled while the metrics of a tick are measured,
+// which the metrics share instead of each labelling the grid again
+type domainCache struct {
+	labels []int
+	sizes  []int
+}
+
+// find the connected domains of identical culture on the grid, returning the
+// domain of every cell, an index into the sizes of the domains, or -1 for an
+// empty cell. While the metrics are measured the domains are only labelled
+// once
+func (s *Sim) domains() (labels []int, sizes []int) {
+	if s.domainCache != nil && s.domainCache.labels != nil {
+		return s.domainCache.labels, s.domainCache.sizes
+	}
+	labels = s.labelDomains()
+	for _, label := range labels {
+		if label < 0 {
+			continue
+		}
+		if label == len(sizes) {
+			sizes = append(sizes, 0)
+		}
+		sizes[label]++
+	}
+	if s.domainCache != nil {
+		s.domainCache.labels, s.domainCache.sizes = labels, sizes
+	}
+	return
+}
+
+// label the connected domains of identical culture on the grid with
+// union-find over the neighbours of every cell, returning the domain of every
+// cell, or -1 for an empty cell. The domains are labelled from 0 in the order
+// of the lowest index of their cells, so the same grid always has the same
+// labels
+func (s *Sim) labelDomains() []int {
+	// every domain is a tree of cells whose root is its lowest index
+	parent := make([]int, len(s.Cells))
+	for n := range parent {
+		parent[n] = n
+	}
+	find := func(n int) int {
+		for parent[n] != n {
+			parent[n] = parent[parent[n]]
+			n = parent[n]
+		}
+		return n
+	}
+	for n := range s.Cells {
+		rgb := s.Cells[n].getRGB()
+		if rgb == Empty {
+			continue
+		}
+		for _, neighbour := range s.neighbourTable[n] {
+			if s.Cells[neighbour].getRGB() != rgb {
+				continue
+			}
+			if a, b := find(n), find(neighbour); a < b {
+				parent[b] = a
+			} else if b < a {
+				parent[a] = b
+			}
+		}
+	}
+
+	// the root of a domain is the first of its cells in the order of index
+	labels := make([]int, len(s.Cells))
+	count := 0
+	for n := range s.Cells {
+		if s.Cells[n].getRGB() == Empty {
+			labels[n] = -1
+			continue
+		}
+		if root := find(n); root == n {
+			labels[n] = count
+			count++
+		} else {
+			labels[n] = labels[root]
+		}
+	}
+	return labels
+}
+
+// FrozenDomains tells for every domain, in the order of DomainSizes, whether
+// it is frozen, with no active link from any of its cells, so that its
+// culture can never change through an interaction. On a grid that has not
+// become a monoculture the frozen domains are cultural traps, cultures kept
+// apart by sharing no feature with any of their neighbours
+func (s *Sim) FrozenDomains() []bool {
+	labels, sizes := s.domains()
+	frozen := make([]bool, len(sizes))
+	for label := range frozen {
+		frozen[label] = true
+	}
+	for n, label := range labels {
+		if label < 0 || !frozen[label] {
+			continue
+		}
+		for _, neighbour := range s.neighbourTable[n] {
+			if s.activeLink(n, neighbour) {
+				frozen[label] = false
+				break
+			}
+		}
+	}
+	return frozen
+}
+
+// DomainCount counts the connected domains of identical culture on the grid
+func (s *Sim) DomainCount() int {
+	return len(s.DomainSizes())
+}
+
+// LargestDomainFraction is the fraction of the populated cells that are in
+// the largest cultural domain, close to 1 for a monoculture and small for a
+// fragmented grid
+func (s *Sim) LargestDomainFraction() float64 {
+	var largest int
+	for _, size := range s.DomainSizes() {
+		if size > largest {
+			largest = size
+		}
+	}
+	populated := s.PopulatedCount()
+	if populated == 0 {
+		return 0
+	}
+	return float64(largest) / float64(populated)
+}
@@ -0,0 +1,84 @@
+package culture
+
+// build the table of neighbours of every cell; the grid geometry is fixed
+// during a simulation so the neighbours only need to be found once
+func (s *Sim) buildNeighbourTable() {
+	s.neighbourTable = make([][]int, s.Width*s.Height)
+	for n := range s.neighbourTable {
+		s.neighbourTable[n] = s.FindNeighboursIndex(n)
+	}
+}
+
+// FindNeighboursIndex finds the indices of the neighbouring cells within the
+// neighbourhood radius, excluding the cell itself. A von Neumann
+// neighbourhood has the cells within a Manhattan distance of radius (the 4
+// orthogonal cells for radius 1) and a Moore neighbourhood the cells within a
+// Chebyshev distance of radius (all 8 surrounding cells for radius 1).
+// Neighbours beyond the edges of the grid are dropped, unless the grid wraps
+// around on that axis.
+//
+// The order of the neighbours is fixed, as the interactions go through them
+// in order and a seeded simulation must repeat exactly: column by column from
+// left to right, and within a column from top to bottom. For a von Neumann
+// neighbourhood of radius 1 that is west, north, south and east, and for a
+// Moore neighbourhood north-west, west, south-west, north, south, north-east,
+// east and south-east. No map is used, so the order never changes between runs
+func (s *Sim) FindNeighboursIndex(n int) (nb []int) {
+	x, y := n/s.Height, n%s.Height
+	for dx := -s.Radius; dx <= s.Radius; dx++ {
+		for dy := -s.Radius; dy <= s.Radius; dy++ {
+			if s.Neighbourhood == "vonneumann" && abs(dx)+abs(dy) > s.Radius {
+				continue
+			}
+			cx, cy, ok := s.wrapCell(x+dx, y+dy)
+			if !ok {
+				continue
+			}
+			i := s.CellIndex(cx, cy)
+			// wrapped neighbours repeat when the neighbourhood is wider than the grid
+			if i == n || ((s.Wrap || s.WrapX || s.WrapY) && contains(nb, i)) {
+				continue
+			}
+			nb = append(nb, i)
+		}
+	}
+	return
+}
+
+// wrap the column x and row y around the axes of the grid that wrap, a grid
+// wrapping on both axes being a torus and on one a cylinder. Returns false if
+// the cell is beyond an edge that doesn't wrap
+func (s *Sim) wrapCell(x, y int) (int, int, bool) {
+	if s.Wrap || s.WrapX {
+		x = mod(x, s.Width)
+	}
+	if s.Wrap || s.WrapY {
+		y = mod(y, s.Height)
+	}
+	return x, y, x >= 0 && x < s.Width && y >= 0 && y < s.Height
+}
+
+// check if the index is in the list of indices
+func contains(indices []int, i int) bool {
+	for _, j := range indices {
+		if j == i {
+			return true
+		}
+	}
+	return false
+}
+
+// CellIndex is the index of the cell at column x and row y of the drawn
+// grid; cells are created column by column so the index runs down each column
+func (s *Sim) CellIndex(x, y int) int { return x*s.Height + y }
+
+// absolute value of an integer
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// modulo that is always positive, used to wrap indices around the grid
+func mod(a, m int) int { return ((a % m) + m) % m }
@@ -0,0 +1,86 @@
+package culture
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// the neighbours of the cell at index n found by measuring the distance to
+// every other cell of the grid, in index order
+func bruteNeighbours(s *Sim, n int) (nb []int) {
+	x, y := n/s.Height, n%s.Height
+	// the distance along an axis, around it when it wraps
+	along := func(a, b, size int, wraps bool) int {
+		d := abs(a - b)
+		if wraps && size-d < d {
+			d = size - d
+		}
+		return d
+	}
+	for m := range s.Cells {
+		dx := along(x, m/s.Height, s.Width, s.Wrap || s.WrapX)
+		dy := along(y, m%s.Height, s.Height, s.Wrap || s.WrapY)
+		within := dx <= s.Radius && dy <= s.Radius
+		if s.Neighbourhood == "vonneumann" {
+			within = dx+dy <= s.Radius
+		}
+		if m != n && within {
+			nb = append(nb, m)
+		}
+	}
+	return
+}
+
+func TestNeighbourTable(t *testing.T) {
+	for _, neighbourhood := range []string{"vonneumann", "moore"} {
+		for _, radius := range []int{1, 2} {
+			for _, wrap := range []bool{false, true} {
+				for _, size := range [][2]int{{6, 6}, {7, 4}} {
+					name := fmt.Sprintf("%s radius %d wrap %v %dx%d", neighbourhood, radius, wrap, size[0], size[1])
+					params := testParams(size[0])
+					params.Height = size[1]
+					params.Neighbourhood, params.Radius, params.Wrap = neighbourhood, radius, wrap
+					s := newTestSim(t, params, 1, "unique")
+					if len(s.neighbourTable) != len(s.Cells) {
+						t.Fatalf("%s: the table has the neighbours of %d cells, want %d", name, len(s.neighbourTable), len(s.Cells))
+					}
+					for n := range s.Cells {
+						cached := s.neighbourTable[n]
+						if fmt.Sprint(cached) != fmt.Sprint(s.FindNeighboursIndex(n)) {
+							t.Errorf("%s: the cached neighbours of cell %d are %v, want %v", name, n, cached, s.FindNeighboursIndex(n))
+						}
+						sorted := append([]int(nil), cached...)
+						sort.Ints(sorted)
+						if want := bruteNeighbours(s, n); fmt.Sprint(sorted) != fmt.Sprint(want) {
+							t.Errorf("%s: the cached neighbours of cell %d are %v, want %v", name, n, sorted, want)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestNeighbourOrder(t *testing.T) {
+	// the centre cell of a 5 by 5 grid, at column 2 and row 2
+	tests := []struct {
+		neighbourhood string
+		want          []int
+	}{
+		// west, north, south and east
+		{"vonneumann", []int{7, 11, 13, 17}},
+		// north-west, west, south-west, north, south, north-east, east and south-east
+		{"moore", []int{6, 7, 8, 11, 13, 16, 17, 18}},
+	}
+	for _, tt := range tests {
+		params := testParams(5)
+		params.Neighbourhood = tt.neighbourhood
+		s := newTestSim(t, params, 1, "unique")
+		for i := 0; i < 10; i++ {
+			if got := s.FindNeighboursIndex(12); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("the %s neighbours of the centre cell are %v, want %v", tt.neighbourhood, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package culture
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Metric is a named measurement of the grid, taken once every simulation tick.
+// A metric can also describe itself for the screen with a Label method,
+// otherwise it is shown by its name
+type Metric interface {
+	Name() string           // name used to select the metric and in the data log
+	Compute(s *Sim) float64 // measure the current state of the simulation
+}
+
+// a metric computed by a function
+type metric struct {
+	name    string
+	label   string
+	compute func(s *Sim) float64
+}
+
+func (m metric) Name() string           { return m.name }
+func (m metric) Label() string          { return m.label }
+func (m metric) Compute(s *Sim) float64 { return m.compute(s) }
+
+// MetricLabel is the description of the metric shown on screen, its label if
+// it has one or else its name
+func MetricLabel(m Metric) string {
+	if l, ok := m.(interface{ Label() string }); ok {
+		return l.Label()
+	}
+	return m.Name()
+}
+
+// all the metrics that can be selected with SelectMetrics, the built-in
+// metrics followed by the registered ones
+var metricRegistry = []Metric{
+	metric{"distance", "average distance between cultures", func(s *Sim) float64 { return float64(s.FeatureDistAvg()) }},
+	// the exchanges of the tick are divided by the side of the grid, the
+	// width of a square grid, as they have been since the first data logs;
+	// the number of exchanges grows with the interactions, not the grid, so
+	// compare the change of grids of different sizes with care
+	metric{"change", "number of cultural exchanges per side", func(s *Sim) float64 { return float64(s.Exchanges / s.side()) }},
+	metric{"unique", "number of unique cultures", func(s *Sim) float64 { return float64(s.DistinctCultures()) }},
+	metric{"domains", "number of cultural domains", func(s *Sim) float64 { return float64(s.DomainCount()) }},
+	metric{"largest", "largest domain fraction", (*Sim).LargestDomainFraction},
+	metric{"entropy", "entropy of cultures (bits)", (*Sim).Entropy},
+	metric{"gini", "gini coefficient of culture sizes", (*Sim).Gini},
+	metric{"boundaries", "number of cultural boundaries", func(s *Sim) float64 { return float64(s.BoundaryCount()) }},
+	metric{"active", "number of active links", func(s *Sim) float64 { return float64(s.ActiveLinkCount()) }},
+	metric{"media", "number of exchanges with the media", func(s *Sim) float64 { return float64(s.MediaExchanges) }},
+	metric{"drift", "number of cultural drifts", func(s *Sim) float64 { return float64(s.Drifts) }},
+	metric{"migrations", "number of migrations", func(s *Sim) float64 { return float64(s.Migrations) }},
+	metric{"turnover", "number of cells reborn", func(s *Sim) float64 { return float64(s.Turnovers) }},
+	metric{"colonies", "number of colonized cells", func(s *Sim) float64 { return float64(s.Colonies) }},
+	metric{"correlation", "correlation length of cultures", (*Sim).CorrelationLength},
+}
+
+// RegisterMetric adds a metric to the metrics that can be selected with
+// SelectMetrics, returning an error if there is already a metric with its name
+func RegisterMetric(m Metric) error {
+	if _, ok := findMetric(m.Name()); ok || m.Name() == "feature-entropy" {
+		return fmt.Errorf("metric %q is already registered", m.Name())
+	}
+	metricRegistry = append(metricRegistry, m)
+	return nil
+}
+
+// SelectMetrics selects the metrics to compute and log from a
+// comma-separated list of names. The name feature-entropy selects the entropy
+// of every feature, as one metric for each feature
+func (s *Sim) SelectMetrics(names string) error {
+	s.Metrics = nil
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "feature-entropy" {
+			for i := 0; i < s.Features; i++ {
+				s.Metrics = append(s.Metrics, featureEntropyMetric(uint(i)))
+			}
+			continue
+		}
+		m, ok := findMetric(name)
+		if !ok {
+			return fmt.Errorf("unknown metric %q", name)
+		}
+		s.Metrics = append(s.Metrics, m)
+	}
+	s.ResetMetricData()
+	return nil
+}
+
+// the metric of the entropy of the traits of the feature at position pos
+func featureEntropyMetric(pos uint) Metric {
+	return metric{
+		name:    fmt.Sprintf("entropy-%d", pos),
+		label:   fmt.Sprintf("entropy of feature %d (bits)", pos),
+		compute: func(s *Sim) float64 { return s.featureEntropy(pos) },
+	}
+}
+
+// find a metric in the registry by name
+func findMetric(name string) (Metric, bool) {
+	for _, m := range metricRegistry {
+		if m.Name() == name {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// ResetMetricData clears the logged data of the selected metrics
+func (s *Sim) ResetMetricData() {
+	s.MetricData = make([][]string, len(s.Metrics))
+	for i, m := range s.Metrics {
+		s.MetricData[i] = []string{m.Name()}
+	}
+}
+
+// MeasureMetrics computes the selected metrics for the current state of the
+// grid, labelling its domains at most once for all the metrics
+func (s *Sim) MeasureMetrics() (values []float64) {
+	s.domainCache = &domainCache{}
+	defer func() { s.domainCache = nil }()
+	for _, m := range s.Metrics {
+		values = append(values, m.Compute(s))
+	}
+	return
+}
+
+// LogMetrics appends the measured values to the data log
+func (s *Sim) LogMetrics(values []float64) {
+	for i, v := range values {
+		s.MetricData[i] = append(s.MetricData[i], strconv.FormatFloat(v, 'f', -1, 64))
+	}
+}
+
+// WriteMetricsJSON writes the measured values of a tick as a JSON object on
+// its own line
+func (s *Sim) WriteMetricsJSON(w io.Writer, tick int, values []float64) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, `{"tick":%d`, tick)
+	for i, m := range s.Metrics {
+		fmt.Fprintf(&b, `,%q:%s`, m.Name(), strconv.FormatFloat(values[i], 'f', -1, 64))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,59 @@
+package culture
+
+import "testing"
+
+// a metric counting how many times it is computed
+type countingMetric struct {
+	name     string
+	computed *int
+}
+
+func (m countingMetric) Name() string { return m.name }
+func (m countingMetric) Compute(s *Sim) float64 {
+	*m.computed++
+	return 0
+}
+
+func TestSelectMetricsLogsOnlySelected(t *testing.T) {
+	s := newTestSim(t, testParams(6), 1, "unique, largest")
+	runTicks(t, s, 3)
+	if len(s.MetricData) != 2 {
+		t.Fatalf("%d metrics are logged, want 2", len(s.MetricData))
+	}
+	for i, name := range []string{"unique", "largest"} {
+		if s.MetricData[i][0] != name {
+			t.Errorf("metric %d logged is %s, want %s", i, s.MetricData[i][0], name)
+		}
+		if len(s.MetricData[i]) != 4 {
+			t.Errorf("%s is logged for %d ticks, want 3", name, len(s.MetricData[i])-1)
+		}
+	}
+}
+
+func TestSelectMetricsSkipsUnselected(t *testing.T) {
+	saved := metricRegistry
+	t.Cleanup(func() { metricRegistry = saved })
+	var computed int
+	if err := RegisterMetric(countingMetric{"test-counting", &computed}); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSim(t, testParams(6), 1, "unique")
+	runTicks(t, s, 5)
+	if computed != 0 {
+		t.Errorf("the metric that isn't selected is computed %d times", computed)
+	}
+	if err := s.SelectMetrics("unique,test-counting"); err != nil {
+		t.Fatal(err)
+	}
+	runTicks(t, s, 5)
+	if computed != 5 {
+		t.Errorf("the selected metric is computed %d times in 5 ticks, want 5", computed)
+	}
+}
+
+func TestSelectMetricsUnknown(t *testing.T) {
+	s := newTestSim(t, testParams(6), 1, "unique")
+	if err := s.SelectMetrics("unique,no-such-metric"); err == nil {
+		t.Error("selecting an unknown metric succeeded")
+	}
+}
@@ -0,0 +1,65 @@
+package culture
+
+import "fmt"
+
+// Interaction is the record of a cell interacting with one of its neighbours
+// or the mass media, everything needed to apply it again to the same grid
+// without drawing any random numbers
+type Interaction struct {
+	Cell      int  // index of the cell that initiated the interaction
+	Neighbour int  // index of the neighbour it interacted with, or -1 for the mass media
+	Feature   int  // feature whose trait was copied, or -1 if none was picked or the whole culture colonized an empty neighbour
+	Reversed  bool // the trait was copied from the neighbour into the cell, instead of from the cell into the neighbour
+	Fired     bool // the interaction changed a culture
+}
+
+// record the interaction if the simulation is recording
+func (s *Sim) record(in Interaction) {
+	if s.Record != nil {
+		s.Record(in)
+	}
+}
+
+// Replay applies the recorded interactions of a tick in order instead of
+// running the interactions of the tick, which on a grid populated the same
+// way as the recorded simulation changes it exactly as the recording did.
+// Only the interactions that fired change the grid, and the exchange counts
+// are kept as they were. The costs of the interactions aren't replayed, the
+// cells that interacted already spent them
+func (s *Sim) Replay(interactions []Interaction) error {
+	var total tally
+	defer func() {
+		s.Exchanges += total.exchanges
+		s.MediaExchanges += total.media
+		s.Colonies += total.colonies
+	}()
+	if s.Update == "sync" {
+		s.freeze()
+	}
+	for _, in := range interactions {
+		if in.Cell < 0 || in.Cell >= len(s.Cells) || in.Neighbour < -1 || in.Neighbour >= len(s.Cells) ||
+			in.Feature < -1 || in.Feature >= s.Features {
+			return fmt.Errorf("cannot replay interaction %+v on a grid of %d cells with %d features", in, len(s.Cells), s.Features)
+		}
+		if !in.Fired {
+			continue
+		}
+		switch {
+		case in.Neighbour < 0:
+			s.replaceTrait(in.Cell, s.Extract(s.media, uint(in.Feature)), uint(in.Feature))
+			total.media++
+		case in.Feature < 0:
+			s.setRGB(in.Neighbour, s.cultureAt(in.Cell))
+			s.Cells[in.Neighbour].Changes++
+			total.colonies++
+		default:
+			source, target := in.Cell, in.Neighbour
+			if in.Reversed {
+				source, target = in.Neighbour, in.Cell
+			}
+			s.replaceTrait(target, s.Extract(s.cultureAt(source), uint(in.Feature)), uint(in.Feature))
+			total.exchanges++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1477 @@
+// Package culture is a model of the dissemination of culture, in which the
+// cells of a grid each hold a culture and have cultural exchanges with their
+// neighbours, the more similar the cultures the more likely.
+package culture
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CELLSIZE is the size of each cell on the images of the grid, in pixels. It
+// must be set before the grid is populated
+var CELLSIZE = 10
+
+// Empty is the culture of a cell without a population
+const Empty = -1
+
+// Cell is a representation of a cell within the grid
+type Cell struct {
+	X       int
+	Y       int
+	R       int
+	Culture int     // culture integer of the culture of the cell, or Empty
+	Cost    float64 // cumulative cost of the interactions initiated by the cell
+	Changes int     // number of times the culture of the cell has changed, unlike the exchanges not counting those that changed nothing
+
+	// probability of the cell resisting a change to its culture, assigned
+	// when the grid is populated
+	Stubbornness float64
+
+	// opinions of the features of the culture, each between 0 and 1, in the
+	// deffuant model; the culture of the cell is its opinions rounded to traits
+	Opinions []float64
+}
+
+// Params are the parameters of a simulation
+type Params struct {
+	Width             int     // number of columns of cells of the grid
+	Height            int     // number of rows of cells of the grid, the same as the width for a square grid if 0
+	Coverage          float64 // percentage of the grid that is populated with cultures
+	ExactCoverage     bool    // populate exactly the coverage of the cells, instead of each cell with the probability of the coverage
+	Init              string  // initial cultures, random, stripes (bands of rows) or clusters (around seed cells)
+	InitCultures      int     // number of cultures of the stripes and clusters initializations
+	Interactions      int     // number of interactions between cultures per simulation tick, or per populated cell in the per-populated mode
+	NMode             string  // absolute (the interactions of a tick pick any cell) or per-populated (every populated cell interacts that many times on average)
+	Features          int     // number of cultural features
+	Traits            int     // number of possible traits of a cultural feature
+	Neighbourhood     string  // vonneumann (4 orthogonal cells) or moore (8 surrounding cells)
+	Update            string  // async (interactions see earlier changes) or sync (interactions see the grid at the start of the tick)
+	Radius            int     // radius of the neighbourhood of a cell
+	Wrap              bool    // wrap the edges of the grid around so that it becomes a torus
+	WrapX             bool    // wrap the left and right edges of the grid around so that it becomes a cylinder
+	WrapY             bool    // wrap the top and bottom edges of the grid around so that it becomes a cylinder
+	Workers           int     // number of goroutines the interactions of a tick are split across
+	Drift             float64 // probability of a populated cell randomly changing one of its traits every tick
+	DriftPerFeature   string  // comma-separated probabilities of every feature of a populated cell randomly changing its trait every tick, instead of the drift; none if empty
+	Migration         float64 // probability of a populated cell swapping its culture with another randomly picked populated cell every tick
+	Turnover          float64 // probability of a populated cell dying and being reborn with a new culture every tick
+	TurnoverMode      string  // culture of a reborn cell, random (a fresh random culture) or inherit (the culture of a random populated neighbour)
+	MediaCulture      []int   // traits of the culture broadcast by the mass media, one for every feature, or nil without mass media
+	MediaStrength     float64 // probability that an interaction is with the mass media instead of the neighbours
+	InteractionCost   float64 // cost incurred by a cell every time it initiates an interaction
+	Budget            float64 // cumulative interaction cost after which a cell stops initiating interactions (0 for no limit)
+	ShuffleNeighbours bool    // randomize the order in which the neighbours of a cell are interacted with
+	Distance          string  // manhattan (traits are ordered and a distance apart) or hamming (traits are the same or different)
+	Rule              string  // homophily (similar cultures are more likely to exchange) or xenophily (different cultures are)
+	ProbFunc          string  // shape of the probability of an exchange over the distance, linear, exponential or step
+	ProbK             float64 // rate at which the exponential probability falls with the distance
+	ProbShared        int     // number of features 2 cultures must share for the step probability to exchange
+	Temperature       float64 // thermal noise that lets dissimilar cultures exchange, 0 for none
+	Colonize          float64 // probability that a populated cell copies its culture into an empty neighbour it interacts with
+	Stubbornness      string  // distribution of the stubbornness of the cells, a value, uniform:min:max or fraction:p:v, none if empty
+	Model             string  // axelrod (cultures copy discrete traits) or deffuant (cultures are continuous opinions that move towards each other)
+	Confidence        float64 // distance between opinions below which cultures interact in the deffuant model
+	ConvergenceRate   float64 // fraction of the way to their average the opinions of interacting cultures move in the deffuant model
+	Strict            bool    // stop on anomalies instead of logging them
+}
+
+// DefaultParams are the parameters of a simulation with the defaults of the
+// command line, a 36 by 36 grid fully populated with random cultures of 6
+// features of 16 traits in the axelrod model
+func DefaultParams() Params {
+	return Params{
+		Width:           36,
+		Coverage:        1,
+		Init:            "random",
+		InitCultures:    4,
+		Interactions:    100,
+		NMode:           "absolute",
+		Features:        6,
+		Traits:          16,
+		Neighbourhood:   "vonneumann",
+		Update:          "async",
+		Radius:          1,
+		Workers:         1,
+		TurnoverMode:    "random",
+		Distance:        "manhattan",
+		Rule:            "homophily",
+		ProbFunc:        "linear",
+		ProbK:           1,
+		ProbShared:      1,
+		Model:           "axelrod",
+		Confidence:      0.2,
+		ConvergenceRate: 0.5,
+	}
+}
+
+// Sim is a grid of cultures, the parameters it evolves with and the data
+// logged as it evolves
+type Sim struct {
+	Params
+	Cells []Cell // the simulation grid
+
+	Exchanges      int // number of cultural exchanges since the counts were last reset
+	MediaExchanges int // number of cultural exchanges with the mass media since the counts were last reset
+	Colonies       int // number of empty cells colonized since the counts were last reset
+	Drifts         int // number of cells that drifted since the counts were last reset
+	Migrations     int // number of cultures that migrated since the counts were last reset
+	Turnovers      int // number of cells that died and were reborn since the counts were last reset
+
+	Record func(Interaction) // called with every interaction as it happens, nil to not record them
+
+	Metrics    []Metric   // metrics selected for the simulation, in the order they are logged
+	MetricData [][]string // logged values of the selected metrics, one row per metric starting with its name
+
+	// random number generator of the simulation, and of the workers, with
+	// the seed and the sources they draw from, the first of the simulation
+	// followed by those of the workers
+	rng        *rand.Rand
+	workerRngs []*rand.Rand
+	seed       int64
+	sources    []*countingSource
+
+	// a culture is an integer with the trait of each feature packed into its
+	// own group of traitBits bits, so 6 features of 16 traits is the color
+	// integer 0x1A2B3C with one feature for every 4 bits. traitMask is the
+	// mask of the bits of a single trait and masks has the masks used to
+	// replace the traits, one for each feature. Cultures too wide to pack,
+	// such as 10 features of 100 traits, are kept as their traits in the wide
+	// table instead, which is nil for packed cultures
+	traitBits uint
+	traitMask int
+	masks     []int
+	wide      *cultureTable
+
+	// culture integer of the culture of the mass media, Empty without mass media
+	media int
+
+	// maximum total trait distance between 2 cultures, every feature as far
+	// apart as the traits go, used to normalise the probability of a
+	// cultural exchange
+	maxDiff int
+
+	// indices of the neighbours of every cell, built by buildNeighbourTable
+	neighbourTable [][]int
+
+	// probabilities of every feature drifting, nil without drift per feature
+	driftRates []float64
+
+	// draws the stubbornness of a cell from its distribution, nil without stubbornness
+	stubbornness func(rng *rand.Rand) float64
+
+	// cultures of the cells at the start of the tick, which the interactions
+	// read from in the synchronous update mode; nil in the asynchronous mode
+	frozen []int
+
+	// indices of the cells populated at the start of the tick, which the
+	// interactions pick from in the per-populated mode
+	populated []int
+
+	// domains of the grid shared by the metrics while they are measured, nil
+	// otherwise
+	domainCache *domainCache
+
+	// locks of the cells, only used when the interactions run on several workers
+	cellLocks []sync.Mutex
+}
+
+// NewSim checks the parameters and creates a simulation with them,
+// drawing its random numbers from a generator seeded with seed. The grid is
+// empty until a population is created
+func NewSim(p Params, seed int64) (*Sim, error) {
+	s := &Sim{Params: p}
+	if s.Height == 0 {
+		s.Height = s.Width
+	}
+	if s.Width < 1 || s.Height < 1 {
+		return nil, fmt.Errorf("width and height must be at least 1, got %d by %d", s.Width, s.Height)
+	}
+	if s.Coverage < 0 || s.Coverage > 1 {
+		return nil, fmt.Errorf("coverage must be between 0 and 1, got %v", s.Coverage)
+	}
+	if s.Interactions < 0 {
+		return nil, fmt.Errorf("interactions cannot be negative, got %d", s.Interactions)
+	}
+	if s.NMode != "absolute" && s.NMode != "per-populated" {
+		return nil, fmt.Errorf("unknown n-mode %q, must be absolute or per-populated", s.NMode)
+	}
+	if s.Drift < 0 || s.Drift > 1 {
+		return nil, fmt.Errorf("drift must be a probability between 0 and 1, got %v", s.Drift)
+	}
+	if s.DriftPerFeature != "" && s.Drift > 0 {
+		return nil, fmt.Errorf("only one of drift and drift per feature can be used")
+	}
+	if s.Migration < 0 || s.Migration > 1 {
+		return nil, fmt.Errorf("migration must be a probability between 0 and 1, got %v", s.Migration)
+	}
+	if s.Turnover < 0 || s.Turnover > 1 {
+		return nil, fmt.Errorf("turnover must be a probability between 0 and 1, got %v", s.Turnover)
+	}
+	if s.TurnoverMode != "random" && s.TurnoverMode != "inherit" {
+		return nil, fmt.Errorf("unknown turnover mode %q, must be random or inherit", s.TurnoverMode)
+	}
+	if s.MediaStrength < 0 || s.MediaStrength > 1 {
+		return nil, fmt.Errorf("media strength must be a probability between 0 and 1, got %v", s.MediaStrength)
+	}
+	if s.Colonize < 0 || s.Colonize > 1 {
+		return nil, fmt.Errorf("colonize must be a probability between 0 and 1, got %v", s.Colonize)
+	}
+	if s.Temperature < 0 {
+		return nil, fmt.Errorf("temperature cannot be negative, got %v", s.Temperature)
+	}
+	if s.Distance != "manhattan" && s.Distance != "hamming" {
+		return nil, fmt.Errorf("unknown distance %q, must be manhattan or hamming", s.Distance)
+	}
+	if err := s.setupCultures(); err != nil {
+		return nil, err
+	}
+	s.media = Empty
+	if s.MediaCulture != nil {
+		media, err := s.Culture(s.MediaCulture)
+		if err != nil {
+			return nil, fmt.Errorf("invalid media culture: %s", err)
+		}
+		s.media = media
+	}
+	if s.Neighbourhood != "vonneumann" && s.Neighbourhood != "moore" {
+		return nil, fmt.Errorf("unknown neighbourhood %q, must be vonneumann or moore", s.Neighbourhood)
+	}
+	if s.Update != "async" && s.Update != "sync" {
+		return nil, fmt.Errorf("unknown update mode %q, must be async or sync", s.Update)
+	}
+	if s.Radius < 1 {
+		return nil, fmt.Errorf("radius must be at least 1, got %d", s.Radius)
+	}
+	if s.Rule != "homophily" && s.Rule != "xenophily" {
+		return nil, fmt.Errorf("unknown rule %q, must be homophily or xenophily", s.Rule)
+	}
+	if s.ProbFunc != "linear" && s.ProbFunc != "exponential" && s.ProbFunc != "step" {
+		return nil, fmt.Errorf("unknown probability function %q, must be linear, exponential or step", s.ProbFunc)
+	}
+	if s.ProbK < 0 {
+		return nil, fmt.Errorf("prob-k cannot be negative, got %v", s.ProbK)
+	}
+	if s.ProbShared < 0 || s.ProbShared > s.Features {
+		return nil, fmt.Errorf("prob-shared must be between 0 and the %d features, got %d", s.Features, s.ProbShared)
+	}
+	if s.Model != "axelrod" && s.Model != "deffuant" {
+		return nil, fmt.Errorf("unknown model %q, must be axelrod or deffuant", s.Model)
+	}
+	if s.Model == "deffuant" && s.Update != "async" {
+		return nil, fmt.Errorf("the deffuant model only runs with the async update mode, got %q", s.Update)
+	}
+	if s.Confidence < 0 || s.Confidence > 1 {
+		return nil, fmt.Errorf("confidence must be between 0 and 1, got %v", s.Confidence)
+	}
+	if s.ConvergenceRate < 0 || s.ConvergenceRate > 1 {
+		return nil, fmt.Errorf("convergence rate must be between 0 and 1, got %v", s.ConvergenceRate)
+	}
+	var err error
+	if s.stubbornness, err = parseStubbornness(s.Stubbornness); err != nil {
+		return nil, err
+	}
+	if s.driftRates, err = s.parseDriftRates(s.DriftPerFeature); err != nil {
+		return nil, err
+	}
+	if s.Init != "random" && s.Init != "stripes" && s.Init != "clusters" {
+		return nil, fmt.Errorf("unknown initialization %q, must be random, stripes or clusters", s.Init)
+	}
+	if s.Init != "random" && s.InitCultures < 1 {
+		return nil, fmt.Errorf("need at least 1 initial culture, got %d", s.InitCultures)
+	}
+
+	// a fixed seed makes the simulation reproducible
+	s.seed = seed
+	s.seedRngs()
+	return s, nil
+}
+
+// get the culture integer of the cell, in the form 0x1A2B3C for 6 features
+// of 16 traits
+func (c *Cell) getRGB() int {
+	return c.Culture
+}
+
+// check if the cell has spent more than its budget on interactions and can
+// no longer initiate them
+func (c *Cell) exhausted(budget float64) bool {
+	return budget > 0 && c.Cost > budget
+}
+
+// set the culture integer of the cell at index n. In the deffuant model the
+// opinions of the features whose trait changed are moved to the new trait
+func (s *Sim) setRGB(n, i int) {
+	if s.Model == "deffuant" {
+		s.syncOpinions(n, i)
+	}
+	s.Cells[n].Culture = i
+}
+
+// Color is the color the cell at index n is drawn with, derived from its
+// culture when it is drawn
+func (s *Sim) Color(n int) color.Color {
+	return s.CultureColor(s.Cells[n].getRGB())
+}
+
+// create a cell
+func (s *Sim) createCell(x, y, clr int) (c Cell) {
+	c = Cell{
+		X:       x,
+		Y:       y,
+		R:       CELLSIZE, // radius of cell
+		Culture: clr,
+	}
+	if s.Model == "deffuant" && clr != Empty {
+		c.Opinions = s.cultureOpinions(clr)
+	}
+	return
+}
+
+// set up the packing of cultures for the number of features and traits, or
+// the table of the cultures that are too wide to pack
+func (s *Sim) setupCultures() error {
+	if s.Features < 1 || s.Traits < 2 {
+		return fmt.Errorf("need at least 1 feature and 2 traits, got %d features and %d traits", s.Features, s.Traits)
+	}
+	s.traitBits = uint(bits.Len(uint(s.Traits - 1)))
+	s.maxDiff = s.Features * (s.Traits - 1)
+	if s.Distance == "hamming" {
+		s.maxDiff = s.Features
+	}
+	if s.Features*int(s.traitBits) > 62 {
+		s.wide = newCultureTable()
+		return nil
+	}
+	s.traitMask = 1<<s.traitBits - 1
+	cultureMask := 1<<(uint(s.Features)*s.traitBits) - 1
+	s.masks = make([]int, s.Features)
+	for i := range s.masks {
+		s.masks[i] = cultureMask &^ (s.traitMask << (s.traitBits * uint(i)))
+	}
+	return nil
+}
+
+// create a culture with a random trait for every feature
+func (s *Sim) randomCulture() int {
+	traits := make([]int, s.Features)
+	for i := range traits {
+		traits[i] = s.rng.Intn(s.Traits)
+	}
+	return s.cultureOf(traits)
+}
+
+// the culture integer of the traits, one for every feature, packed or from
+// the table of wide cultures
+func (s *Sim) cultureOf(traits []int) (c int) {
+	if s.wide != nil {
+		return s.wide.culture(traits)
+	}
+	for i, trait := range traits {
+		c = s.Replace(c, trait, uint(i))
+	}
+	return
+}
+
+// CultureColor is the color a culture is drawn with. With 6 features of 16
+// traits the culture is the color integer itself, otherwise the culture is
+// hashed into a color so that different cultures get different colors. A wide
+// culture is hashed from its traits, so that it has the same color in every
+// simulation
+func (s *Sim) CultureColor(i int) color.Color {
+	switch {
+	case i == Empty:
+		return color.RGBA{0, 0, 0, uint8(255)}
+	case s.wide != nil:
+		// FNV-1a of the traits
+		h := uint64(14695981039346656037)
+		for _, trait := range s.wide.copyTraits(i) {
+			h = (h ^ uint64(trait)) * 1099511628211
+		}
+		i = int(h >> 40)
+	case s.Features != 6 || s.traitBits != 4:
+		i = int((uint64(i) * 0x9E3779B97F4A7C15) >> 40)
+	}
+	return color.RGBA{getR(i), getG(i), getB(i), uint8(255)}
+}
+
+// ColorCulture is the culture drawn with the color, the inverse of
+// CultureColor. A transparent color is an empty cell. Only with 6 features of
+// 16 traits is the culture the color integer itself, for other cultures the
+// color is hashed and can't be turned back into a culture
+func (s *Sim) ColorCulture(clr color.Color) (int, error) {
+	if s.Features != 6 || s.traitBits != 4 {
+		return Empty, fmt.Errorf("colors can only be turned into cultures of 6 features of 16 traits, not %d features of %d traits", s.Features, s.Traits)
+	}
+	r, g, b, a := clr.RGBA()
+	if a < 0x8000 {
+		return Empty, nil
+	}
+	return int(r>>8)<<16 | int(g>>8)<<8 | int(b>>8), nil
+}
+
+// CreatePopulation creates the initial population, populating each cell
+// with a probability of the coverage. With exact coverage the number of
+// populated cells is the coverage of the grid rounded to the nearest cell,
+// picked at random. The cultures of the populated cells are set by the
+// initialization
+func (s *Sim) CreatePopulation() {
+	cultures := make([]int, s.Width*s.Height)
+	initCulture := s.initCultures()
+	if s.ExactCoverage {
+		for n := range cultures {
+			cultures[n] = Empty
+		}
+		populated := int(math.Round(s.Coverage * float64(len(cultures))))
+		if populated > len(cultures) {
+			populated = len(cultures)
+		}
+		for _, n := range s.rng.Perm(len(cultures))[:populated] {
+			cultures[n] = initCulture(n)
+		}
+		s.PopulateGrid(cultures)
+		return
+	}
+	for n := range cultures {
+		p := s.rng.Float64()
+		if p < s.Coverage {
+			cultures[n] = initCulture(n)
+		} else {
+			cultures[n] = Empty
+		}
+	}
+	s.PopulateGrid(cultures)
+}
+
+// the function giving the initial culture of the populated cell at index n.
+// A random initialization gives every cell its own random culture. Stripes
+// split the rows of the grid into bands, and clusters give every cell the
+// culture of the nearest of a number of randomly placed seed cells; both with
+// a random culture for every band or seed
+func (s *Sim) initCultures() func(n int) int {
+	if s.Init == "random" {
+		return func(int) int { return s.randomCulture() }
+	}
+	cultures := make([]int, s.InitCultures)
+	for k := range cultures {
+		cultures[k] = s.randomCulture()
+	}
+	if s.Init == "stripes" {
+		return func(n int) int {
+			return cultures[(n%s.Height)*len(cultures)/s.Height]
+		}
+	}
+	seeds := make([]int, len(cultures))
+	for k := range seeds {
+		seeds[k] = s.rng.Intn(s.Width * s.Height)
+	}
+	return func(n int) int {
+		nearest, shortest := 0, -1
+		for k, seed := range seeds {
+			dx, dy := n/s.Height-seed/s.Height, n%s.Height-seed%s.Height
+			if d := dx*dx + dy*dy; shortest < 0 || d < shortest {
+				nearest, shortest = k, d
+			}
+		}
+		return cultures[nearest]
+	}
+}
+
+// position of the centre of the cell at index n on the images of the grid,
+// with the cells laid out column by column and a margin of half a cell
+func (s *Sim) cellPosition(n int) (x, y int) {
+	return (n/s.Height + 1) * CELLSIZE, (n%s.Height + 1) * CELLSIZE
+}
+
+// PopulateGrid creates the cells of the grid with the given cultures; cells
+// are laid out column by column, each with a stubbornness drawn from its
+// distribution. A new grid starts a new data log
+func (s *Sim) PopulateGrid(cultures []int) {
+	s.Cells = make([]Cell, len(cultures))
+	for n, culture := range cultures {
+		x, y := s.cellPosition(n)
+		s.Cells[n] = s.createCell(x, y, culture)
+		if s.stubbornness != nil {
+			s.Cells[n].Stubbornness = s.stubbornness(s.rng)
+		}
+	}
+	if s.Workers > 1 {
+		s.cellLocks = make([]sync.Mutex, len(s.Cells))
+	}
+	s.buildNeighbourTable()
+	s.ResetMetricData()
+}
+
+// ValidCulture checks if the culture integer is empty or a valid culture for
+// the number of features and traits
+func (s *Sim) ValidCulture(c int) bool {
+	if c == Empty {
+		return true
+	}
+	if s.wide != nil {
+		return c >= 0 && c < s.wide.len()
+	}
+	if c < 0 || c>>(uint(s.Features)*s.traitBits) != 0 {
+		return false
+	}
+	for i := 0; i < s.Features; i++ {
+		if s.Extract(c, uint(i)) >= s.Traits {
+			return false
+		}
+	}
+	return true
+}
+
+// CultureTraits are the traits of the culture, one for every feature, nil for
+// the empty culture
+func (s *Sim) CultureTraits(c int) []int {
+	if c == Empty {
+		return nil
+	}
+	if s.wide != nil {
+		return s.wide.copyTraits(c)
+	}
+	traits := make([]int, s.Features)
+	for i := range traits {
+		traits[i] = s.Extract(c, uint(i))
+	}
+	return traits
+}
+
+// Culture is the culture integer of the traits, which must have a trait in
+// [0, traits) for every feature
+func (s *Sim) Culture(traits []int) (int, error) {
+	if err := s.checkTraits(traits); err != nil {
+		return Empty, err
+	}
+	return s.cultureOf(traits), nil
+}
+
+// check that there is a trait in [0, traits) for every feature
+func (s *Sim) checkTraits(traits []int) error {
+	if len(traits) != s.Features {
+		return fmt.Errorf("culture has %d traits but there are %d features", len(traits), s.Features)
+	}
+	for i, trait := range traits {
+		if trait < 0 || trait >= s.Traits {
+			return fmt.Errorf("trait %d of feature %d is not in [0, %d)", trait, i, s.Traits)
+		}
+	}
+	return nil
+}
+
+// FormatCulture writes the culture for the files of the simulation. A packed
+// culture is written as its culture integer and a wide culture as its traits
+// separated by colons, as the culture integer of a wide culture only holds
+// within the simulation. The empty culture is written as -1
+func (s *Sim) FormatCulture(c int) string {
+	if c == Empty || s.wide == nil {
+		return strconv.Itoa(c)
+	}
+	traits := s.wide.copyTraits(c)
+	fields := make([]string, len(traits))
+	for i, trait := range traits {
+		fields[i] = strconv.Itoa(trait)
+	}
+	return strings.Join(fields, ":")
+}
+
+// ParseCulture reads a culture written by FormatCulture
+func (s *Sim) ParseCulture(str string) (int, error) {
+	if strings.Contains(str, ":") {
+		traits, err := splitTraits(str)
+		if err != nil {
+			return Empty, err
+		}
+		return s.Culture(traits)
+	}
+	c, err := strconv.Atoi(str)
+	if err != nil {
+		return Empty, err
+	}
+	if c != Empty && (s.wide != nil || !s.ValidCulture(c)) {
+		return Empty, fmt.Errorf("invalid culture %s for %d features of %d traits", str, s.Features, s.Traits)
+	}
+	return c, nil
+}
+
+// ParseTraits reads the traits of a culture of the number of features and
+// traits given on the command line, either as the trait of every feature
+// from the first separated by colons, such as 12:3:11:2:10:1, or for cultures
+// that pack into an integer as the hex culture integer, such as the same
+// culture 0x1A2B3C
+func ParseTraits(spec string, features, traits int) ([]int, error) {
+	if strings.Contains(spec, ":") {
+		return splitTraits(spec)
+	}
+	if features < 1 || traits < 2 {
+		return nil, fmt.Errorf("need at least 1 feature and 2 traits, got %d features and %d traits", features, traits)
+	}
+	traitBits := uint(bits.Len(uint(traits - 1)))
+	if features*int(traitBits) > 62 {
+		return nil, fmt.Errorf("%d features of %d traits do not fit into a hex culture integer, give the traits separated by colons", features, traits)
+	}
+	c, err := strconv.ParseInt(strings.TrimPrefix(strings.ToLower(spec), "0x"), 16, 64)
+	if err != nil {
+		return nil, err
+	}
+	if c < 0 || c>>(uint(features)*traitBits) != 0 {
+		return nil, fmt.Errorf("culture %s has more than %d features", spec, features)
+	}
+	t := make([]int, features)
+	for i := range t {
+		t[i] = int(c>>(traitBits*uint(i))) & (1<<traitBits - 1)
+	}
+	return t, nil
+}
+
+// the traits of a culture written as integers separated by colons
+func splitTraits(str string) ([]int, error) {
+	fields := strings.Split(str, ":")
+	traits := make([]int, len(fields))
+	for i, field := range fields {
+		trait, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trait %q in culture %s", field, str)
+		}
+		traits[i] = trait
+	}
+	return traits, nil
+}
+
+// counts of the cultural changes made by interactions
+type tally struct {
+	exchanges int // exchanges between neighbours
+	media     int // exchanges with the mass media
+	colonies  int // empty cells colonized
+}
+
+// add the counts of another tally
+func (t *tally) add(o tally) {
+	t.exchanges += o.exchanges
+	t.media += o.media
+	t.colonies += o.colonies
+}
+
+// in the synchronous mode every interaction of the tick sees the grid as it
+// was at the start of the tick. The snapshot buffer is reused across ticks, so
+// it costs one copy of the cultures per tick
+func (s *Sim) freeze() {
+	if len(s.frozen) != len(s.Cells) {
+		s.frozen = make([]int, len(s.Cells))
+	}
+	for n := range s.Cells {
+		s.frozen[n] = s.Cells[n].getRGB()
+	}
+}
+
+// RunInteractions runs the interactions of one simulation tick, each between
+// a randomly picked cell and its neighbours. In the per-populated mode there
+// are Interactions for every cell populated at the start of the tick, and
+// only those cells are picked, so no interaction is wasted on an empty cell
+// and the rate of interaction doesn't depend on the coverage. With several
+// workers the interactions are split across goroutines, each drawing from its
+// own random number generator and locking the cells it interacts with. The
+// order in which the workers interleave depends on scheduling, so unlike a
+// single worker the outcome is not reproducible from the seed
+func (s *Sim) RunInteractions() error {
+	var total tally
+	defer func() {
+		s.Exchanges += total.exchanges
+		s.MediaExchanges += total.media
+		s.Colonies += total.colonies
+	}()
+
+	if s.Update == "sync" {
+		s.freeze()
+	}
+
+	// there is no cell to pick before the grid is populated
+	if len(s.Cells) == 0 {
+		return nil
+	}
+	count := s.Interactions
+	pick := func(rng *rand.Rand) int { return rng.Intn(len(s.Cells)) }
+	if s.NMode == "per-populated" {
+		s.populated = s.populated[:0]
+		for n := range s.Cells {
+			if s.Cells[n].getRGB() != Empty {
+				s.populated = append(s.populated, n)
+			}
+		}
+		if len(s.populated) == 0 {
+			return nil
+		}
+		count = s.Interactions * len(s.populated)
+		pick = func(rng *rand.Rand) int { return s.populated[rng.Intn(len(s.populated))] }
+	}
+	if len(s.workerRngs) == 0 {
+		for c := 0; c < count; c++ {
+			changes, err := s.interact(pick(s.rng), s.rng)
+			total.add(changes)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	counts := make([]tally, len(s.workerRngs))
+	errs := make([]error, len(s.workerRngs))
+	for w := range s.workerRngs {
+		// spread the interactions evenly over the workers
+		share := count / len(s.workerRngs)
+		if w < count%len(s.workerRngs) {
+			share++
+		}
+		wg.Add(1)
+		go func(w, share int) {
+			defer wg.Done()
+			for c := 0; c < share && errs[w] == nil; c++ {
+				var changes tally
+				changes, errs[w] = s.interact(pick(s.workerRngs[w]), s.workerRngs[w])
+				counts[w].add(changes)
+			}
+		}(w, share)
+	}
+	wg.Wait()
+	for w := range counts {
+		total.add(counts[w])
+		if errs[w] != nil {
+			return errs[w]
+		}
+	}
+	return nil
+}
+
+// get the cell at index r to have cultural exchange with its neighbours, or
+// with the mass media, returning the number of exchanges that happened
+func (s *Sim) interact(r int, rng *rand.Rand) (changes tally, err error) {
+	// cells that have spent their budget no longer initiate interactions
+	s.lockCells(r, r)
+	active := s.cultureAt(r) != Empty && !s.Cells[r].exhausted(s.Budget)
+	if active {
+		s.Cells[r].Cost += s.InteractionCost
+	}
+	s.unlockCells(r, r)
+	if !active {
+		return
+	}
+
+	// the mass media takes the place of the neighbours
+	if s.media != Empty && rng.Float64() < s.MediaStrength {
+		s.lockCells(r, r)
+		changed, err := s.adoptMedia(r, rng)
+		s.unlockCells(r, r)
+		if changed {
+			changes.media++
+		}
+		return changes, s.anomaly(err)
+	}
+
+	neighbours := s.neighbourTable[r]
+	// interacting in a fixed order biases which neighbour influences first
+	if s.ShuffleNeighbours {
+		neighbours = append([]int(nil), neighbours...)
+		rng.Shuffle(len(neighbours), func(i, j int) {
+			neighbours[i], neighbours[j] = neighbours[j], neighbours[i]
+		})
+	}
+	for _, neighbour := range neighbours {
+		var changed, colonized bool
+		var err error
+		s.lockCells(r, neighbour)
+		if s.cultureAt(neighbour) == Empty {
+			colonized = s.colonize(r, neighbour, rng)
+		} else if s.Model == "deffuant" {
+			changed = s.converge(r, neighbour)
+		} else {
+			changed, err = s.exchange(r, neighbour, rng)
+		}
+		s.unlockCells(r, neighbour)
+		if changed {
+			changes.exchanges++
+		}
+		if colonized {
+			changes.colonies++
+		}
+		if err = s.anomaly(err); err != nil {
+			return changes, err
+		}
+	}
+	return
+}
+
+// the culture of the cell at index n that interactions see, which in the
+// synchronous mode is the culture at the start of the tick
+func (s *Sim) cultureAt(n int) int {
+	if s.frozen != nil {
+		return s.frozen[n]
+	}
+	return s.Cells[n].getRGB()
+}
+
+// log an anomaly and carry on, unless the simulation is strict in which case
+// the anomaly is returned as an error
+func (s *Sim) anomaly(err error) error {
+	if err != nil && !s.Strict {
+		log.Println(err)
+		return nil
+	}
+	return err
+}
+
+// cultural exchange between the cell at index r and its neighbour depending
+// on the calculated probability. The more similar the cultures are, the more
+// likely there will be cultural exchange. The exchange is decided on the
+// cultures that interactions see, and applied to the current culture of the
+// cell that changes. Returns true if there was an exchange, which is counted
+// in the exchanges even if the feature picked is one the cultures already
+// share; only the changes of the cells count an exchange that changed a trait
+func (s *Sim) exchange(r, neighbour int, rng *rand.Rand) (exchanged bool, err error) {
+	if s.cultureAt(neighbour) == Empty {
+		return false, nil
+	}
+	in := Interaction{Cell: r, Neighbour: neighbour, Feature: -1}
+	defer func() {
+		in.Fired = exchanged
+		s.record(in)
+	}()
+	// cultural differences between the neighbour
+	d := s.CultureDiff(s.cultureAt(r), s.cultureAt(neighbour))
+	// probability of a cultural exchange happening
+	probability, err := s.exchangeProbability(s.cultureAt(r), s.cultureAt(neighbour), d)
+	dp := rng.Float64()
+	// cultural exchange happens
+	if dp < probability {
+		// randomly select one of the features
+		i := rng.Intn(s.Features)
+		in.Feature = i
+		if d != 0 {
+			// randomly select either the cell or the neighbour to
+			// have its trait replaced by the other's
+			source, target := r, neighbour
+			if rng.Intn(2) == 1 {
+				source, target = neighbour, r
+				in.Reversed = true
+			}
+			if s.resists(target, rng) {
+				return false, err
+			}
+			s.replaceTrait(target, s.Extract(s.cultureAt(source), uint(i)), uint(i))
+			return true, err
+		}
+	}
+	return false, err
+}
+
+// replace the trait of the feature at pos of the culture of the cell at index
+// n, counting a change of the cell only if its culture changed
+func (s *Sim) replaceTrait(n, trait int, pos uint) {
+	old := s.Cells[n].getRGB()
+	if c := s.Replace(old, trait, pos); c != old {
+		s.setRGB(n, c)
+		s.Cells[n].Changes++
+	}
+}
+
+// the cell at index r spreading into its empty neighbour, which with the
+// colonize probability takes on the whole culture of the cell. Returns true
+// if the neighbour was colonized
+func (s *Sim) colonize(r, neighbour int, rng *rand.Rand) (colonized bool) {
+	defer func() { s.record(Interaction{Cell: r, Neighbour: neighbour, Feature: -1, Fired: colonized}) }()
+	if s.Colonize == 0 || s.Cells[neighbour].getRGB() != Empty || rng.Float64() >= s.Colonize {
+		return false
+	}
+	s.setRGB(neighbour, s.cultureAt(r))
+	s.Cells[neighbour].Changes++
+	return true
+}
+
+// cultural exchange between the cell at index r and the mass media, with the
+// same probability as an exchange between neighbours. Only the cell adopts a
+// trait, the media culture never changes. Returns true if there was an
+// exchange, which like an exchange between neighbours only changes the cell
+// if it doesn't already share the feature picked with the media
+func (s *Sim) adoptMedia(r int, rng *rand.Rand) (exchanged bool, err error) {
+	in := Interaction{Cell: r, Neighbour: -1, Feature: -1}
+	defer func() {
+		in.Fired = exchanged
+		s.record(in)
+	}()
+	d := s.CultureDiff(s.cultureAt(r), s.media)
+	probability, err := s.exchangeProbability(s.cultureAt(r), s.media, d)
+	if rng.Float64() < probability {
+		i := rng.Intn(s.Features)
+		in.Feature = i
+		if d != 0 && !s.resists(r, rng) {
+			s.replaceTrait(r, s.Extract(s.media, uint(i)), uint(i))
+			return true, err
+		}
+	}
+	return false, err
+}
+
+// ApplyDrift applies cultural drift, where every populated cell has a
+// probability of one of its features randomly changing to a different trait.
+// With drift per feature every feature of the cell instead changes on its own
+// with its own probability, so that features can innovate at different
+// speeds. Returns the number of cells that drifted
+func (s *Sim) ApplyDrift() (count int) {
+	defer func() { s.Drifts += count }()
+	for n := range s.Cells {
+		if s.Cells[n].getRGB() == Empty {
+			continue
+		}
+		if s.driftRates != nil {
+			drifted := false
+			for i, rate := range s.driftRates {
+				if s.rng.Float64() < rate {
+					s.mutate(n, uint(i))
+					drifted = true
+				}
+			}
+			if drifted {
+				count++
+			}
+			continue
+		}
+		if s.rng.Float64() >= s.Drift {
+			continue
+		}
+		s.mutate(n, uint(s.rng.Intn(s.Features)))
+		count++
+	}
+	return
+}
+
+// change the trait of feature i of the cell to a random different trait
+func (s *Sim) mutate(n int, i uint) {
+	trait := s.rng.Intn(s.Traits - 1)
+	if trait >= s.Extract(s.Cells[n].getRGB(), i) {
+		trait++
+	}
+	s.setRGB(n, s.Replace(s.Cells[n].getRGB(), trait, i))
+	s.Cells[n].Changes++
+}
+
+// parse the drift rates per feature, a comma-separated probability for every
+// feature, or none if the spec is empty
+func (s *Sim) parseDriftRates(spec string) ([]float64, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	fields := strings.Split(spec, ",")
+	if len(fields) != s.Features {
+		return nil, fmt.Errorf("drift per feature %q needs a rate for every one of the %d features, got %d", spec, s.Features, len(fields))
+	}
+	rates := make([]float64, len(fields))
+	for i, field := range fields {
+		rate, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("drift per feature %q has rate %q for feature %d, must be a probability between 0 and 1", spec, field, i)
+		}
+		rates[i] = rate
+	}
+	return rates, nil
+}
+
+// ApplyMigration applies migration, where every populated cell has a
+// probability of swapping its culture with another randomly picked populated
+// cell. The cells stay in place and their cultures, with their opinions in the
+// deffuant model, move. Returns the number of migrations
+func (s *Sim) ApplyMigration() (count int) {
+	defer func() { s.Migrations += count }()
+	var populated []int
+	for n := range s.Cells {
+		if s.Cells[n].getRGB() != Empty {
+			populated = append(populated, n)
+		}
+	}
+	if len(populated) < 2 {
+		return
+	}
+	for _, n := range populated {
+		if s.rng.Float64() >= s.Migration {
+			continue
+		}
+		// pick any other populated cell
+		other := populated[s.rng.Intn(len(populated)-1)]
+		if other == n {
+			other = populated[len(populated)-1]
+		}
+		a, b := &s.Cells[n], &s.Cells[other]
+		if a.Culture != b.Culture {
+			a.Culture, b.Culture = b.Culture, a.Culture
+			a.Opinions, b.Opinions = b.Opinions, a.Opinions
+			a.Changes++
+			b.Changes++
+		}
+		count++
+	}
+	return
+}
+
+// ApplyTurnover applies the turnover of generations, where every populated
+// cell has a probability of dying and being reborn in its place, so the
+// coverage stays the same. The reborn cell is a new cell with no cost and
+// its own stubbornness, with a fresh random culture, or in the inherit mode
+// the culture (and opinions) of a randomly picked populated neighbour, or a
+// random culture if it has none. Returns the number of cells reborn
+func (s *Sim) ApplyTurnover() (count int) {
+	defer func() { s.Turnovers += count }()
+	var parents []int
+	for n := range s.Cells {
+		if s.Cells[n].getRGB() == Empty || s.rng.Float64() >= s.Turnover {
+			continue
+		}
+		parent := -1
+		if s.TurnoverMode == "inherit" {
+			parents = parents[:0]
+			for _, neighbour := range s.neighbourTable[n] {
+				if s.Cells[neighbour].getRGB() != Empty {
+					parents = append(parents, neighbour)
+				}
+			}
+			if len(parents) > 0 {
+				parent = parents[s.rng.Intn(len(parents))]
+			}
+		}
+		culture := s.randomCulture()
+		if parent >= 0 {
+			culture = s.Cells[parent].getRGB()
+		}
+		old := s.Cells[n]
+		s.Cells[n] = s.createCell(old.X, old.Y, culture)
+		s.Cells[n].Changes = old.Changes + 1
+		if parent >= 0 && s.Cells[n].Opinions != nil {
+			copy(s.Cells[n].Opinions, s.Cells[parent].Opinions)
+		}
+		if s.stubbornness != nil {
+			s.Cells[n].Stubbornness = s.stubbornness(s.rng)
+		}
+		count++
+	}
+	return
+}
+
+// Inject the culture into a randomly picked populated cell, and the populated
+// cells around it within the radius, overwriting their cultures. Returns the
+// number of cells injected, 0 if no cell is populated
+func (s *Sim) Inject(culture, radius int) (count int) {
+	var populated []int
+	for n := range s.Cells {
+		if s.Cells[n].getRGB() != Empty {
+			populated = append(populated, n)
+		}
+	}
+	if len(populated) == 0 {
+		return
+	}
+	n := populated[s.rng.Intn(len(populated))]
+	x, y := n/s.Height, n%s.Height
+	for dx := -radius; dx <= radius; dx++ {
+		for dy := -radius; dy <= radius; dy++ {
+			cx, cy, ok := s.wrapCell(x+dx, y+dy)
+			if !ok {
+				continue
+			}
+			i := s.CellIndex(cx, cy)
+			if s.Cells[i].getRGB() == Empty {
+				continue
+			}
+			if s.Cells[i].getRGB() != culture {
+				s.setRGB(i, culture)
+				s.Cells[i].Changes++
+			}
+			count++
+		}
+	}
+	return
+}
+
+// lock the cells at indices a and b, the lower index first so that workers
+// locking the same cells can't deadlock
+func (s *Sim) lockCells(a, b int) {
+	if s.cellLocks == nil {
+		return
+	}
+	if a > b {
+		a, b = b, a
+	}
+	s.cellLocks[a].Lock()
+	if b != a {
+		s.cellLocks[b].Lock()
+	}
+}
+
+// unlock the cells at indices a and b
+func (s *Sim) unlockCells(a, b int) {
+	if s.cellLocks == nil {
+		return
+	}
+	s.cellLocks[a].Unlock()
+	if b != a {
+		s.cellLocks[b].Unlock()
+	}
+}
+
+// the color integer is 0x1A2B3CFF where
+// 1A is the red, 2B is green and 3C is blue
+
+// get the red (R) from the color integer i
+func getR(i int) uint8 {
+	return uint8((i >> 16) & 0x0000FF)
+}
+
+// get the green (G) from the color integer i
+func getG(i int) uint8 {
+	return uint8((i >> 8) & 0x0000FF)
+}
+
+// get the blue (B) from the color integer i
+func getB(i int) uint8 {
+	return uint8(i & 0x0000FF)
+}
+
+// Diff is the total distance between traits for all features, the number of
+// differing features with the hamming distance, between the cultures of the cells at indices a1 and a2
+func (s *Sim) Diff(a1, a2 int) int {
+	return s.CultureDiff(s.Cells[a1].getRGB(), s.Cells[a2].getRGB())
+}
+
+// CultureDiff is the total distance between traits for all features,
+// between 2 cultures
+func (s *Sim) CultureDiff(c1, c2 int) int {
+	var d int
+	for i := 0; i < s.Features; i++ {
+		d = d + s.traitDistance(c1, c2, uint(i))
+	}
+	return d
+}
+
+// probability of a cultural exchange between 2 cultures c1 and c2 that are a
+// total trait distance d apart. The probability function decides how
+// similarity falls with the distance: linear with 1 - d/maxDiff, exponential
+// with exp(-k*d) for the rate k of ProbK, or a step that is 1 if the cultures
+// share at least ProbShared features and 0 if they don't. With the homophily
+// rule the more similar the more likely, with the probability of the
+// similarity, and with the xenophily rule the more different the more likely,
+// with 1 minus it, which for the linear function is d/maxDiff. A distance
+// beyond maxDiff is clamped to a probability of 0 and reported as an error.
+// With a temperature, an exchange that the similarity rejects is still
+// accepted with the Glauber probability 1/(1+exp(dissimilarity/temperature)),
+// so at a temperature of 0 only the similarity decides
+func (s *Sim) exchangeProbability(c1, c2, d int) (float64, error) {
+	if d > s.maxDiff {
+		return 0, fmt.Errorf("trait distance %d exceeds the maximum distance %d", d, s.maxDiff)
+	}
+	var p float64
+	switch s.ProbFunc {
+	case "exponential":
+		p = math.Exp(-s.ProbK * float64(d))
+	case "step":
+		if s.sharedFeatures(c1, c2) >= s.ProbShared {
+			p = 1
+		}
+	default:
+		p = 1 - float64(d)/float64(s.maxDiff)
+	}
+	if s.Rule == "xenophily" {
+		p = 1 - p
+		// exactly d/maxDiff, without the rounding of 1 - p
+		if s.ProbFunc == "linear" {
+			p = float64(d) / float64(s.maxDiff)
+		}
+	}
+	if s.Temperature > 0 {
+		p += (1 - p) / (1 + math.Exp((1-p)/s.Temperature))
+	}
+	return p, nil
+}
+
+// number of features with the same trait in 2 cultures
+func (s *Sim) sharedFeatures(c1, c2 int) (shared int) {
+	for i := 0; i < s.Features; i++ {
+		if s.Extract(c1, uint(i)) == s.Extract(c2, uint(i)) {
+			shared++
+		}
+	}
+	return
+}
+
+// FeatureDistAvg is the average feature distance for the whole grid
+func (s *Sim) FeatureDistAvg() int {
+	var count int
+	var dist int
+	for c := range s.Cells {
+		if s.Cells[c].getRGB() == Empty {
+			continue
+		}
+		for _, neighbour := range s.neighbourTable[c] {
+			if s.Cells[neighbour].getRGB() != Empty {
+				count++
+				dist = dist + s.FeatureDistance(s.Cells[c].getRGB(), s.Cells[neighbour].getRGB())
+			}
+		}
+	}
+	return int(float64(dist/s.side()) * s.Coverage)
+}
+
+// the number of cells on the side of a square grid with as many cells as the
+// grid, which the metrics are normalised by; the width of a square grid
+func (s *Sim) side() int {
+	return int(math.Sqrt(float64(s.Width * s.Height)))
+}
+
+// BoundaryCount counts the links between neighbouring cultures that differ,
+// the edges of the cultural domains. Each pair of neighbours is counted once
+func (s *Sim) BoundaryCount() (count int) {
+	for c := range s.Cells {
+		if s.Cells[c].getRGB() == Empty {
+			continue
+		}
+		for _, neighbour := range s.neighbourTable[c] {
+			if neighbour <= c || s.Cells[neighbour].getRGB() == Empty {
+				continue
+			}
+			if s.Cells[c].getRGB() != s.Cells[neighbour].getRGB() {
+				count++
+			}
+		}
+	}
+	return
+}
+
+// ActiveLinkCount counts the links between neighbouring cultures that can
+// still exchange traits, those that share some but not all of their
+// features, or in the deffuant model whose opinions are apart but within the
+// confidence. Each pair of neighbours is counted once. When there are no
+// active links left the grid has reached an absorbing state
+func (s *Sim) ActiveLinkCount() (count int) {
+	for c := range s.Cells {
+		if s.Cells[c].getRGB() == Empty {
+			continue
+		}
+		for _, neighbour := range s.neighbourTable[c] {
+			if neighbour > c && s.activeLink(c, neighbour) {
+				count++
+			}
+		}
+	}
+	return
+}
+
+// whether the populated cell and its neighbour can still exchange traits,
+// which an empty neighbour can't
+func (s *Sim) activeLink(c, neighbour int) bool {
+	if s.Cells[neighbour].getRGB() == Empty {
+		return false
+	}
+	if s.Model == "deffuant" {
+		d := s.OpinionDistance(c, neighbour)
+		return d >= agreement && d < s.Confidence
+	}
+	d := s.FeatureDistance(s.Cells[c].getRGB(), s.Cells[neighbour].getRGB())
+	return d > 0 && d < s.Features
+}
+
+// FeatureDistance is the number of features in which 2 cultures differ
+func (s *Sim) FeatureDistance(n1, n2 int) int {
+	var same int = 0
+	for i := 0; i < s.Features; i++ {
+		f1, f2 := s.Extract(n1, uint(i)), s.Extract(n2, uint(i))
+		if f1 == f2 {
+			same++
+		}
+	}
+	return s.Features - same
+}
+
+// PopulatedCount counts the cells with a population
+func (s *Sim) PopulatedCount() (count int) {
+	for _, c := range s.Cells {
+		if c.getRGB() != Empty {
+			count++
+		}
+	}
+	return
+}
+
+// DistinctCultures counts the unique cultures of the populated cells, empty
+// cells aren't a culture
+func (s *Sim) DistinctCultures() int {
+	uniques := make(map[int]bool)
+	for _, c := range s.Cells {
+		if rgb := c.getRGB(); rgb != Empty {
+			uniques[rgb] = true
+		}
+	}
+	return len(uniques)
+}
+
+// Monoculture is true if every populated cell has the same culture, and there
+// is at least one populated cell
+func (s *Sim) Monoculture() bool {
+	return s.DistinctCultures() == 1
+}
+
+// CultureFraction is the fraction of the populated cells that have the culture
+func (s *Sim) CultureFraction(culture int) float64 {
+	var count int
+	for _, c := range s.Cells {
+		if c.getRGB() == culture {
+			count++
+		}
+	}
+	populated := s.PopulatedCount()
+	if populated == 0 {
+		return 0
+	}
+	return float64(count) / float64(populated)
+}
+
+// Entropy is the Shannon entropy, in bits, of the distribution of cultures
+// over the populated cells. A single culture (or no population) has an
+// entropy of 0
+func (s *Sim) Entropy() float64 {
+	counts := make(map[int]int)
+	var total int
+	for _, c := range s.Cells {
+		if c.getRGB() != Empty {
+			counts[c.getRGB()]++
+			total++
+		}
+	}
+	return entropy(counts, total)
+}
+
+// Gini is the Gini coefficient of the sizes of the cultures, the number of
+// populated cells of every distinct culture, 0 if all the cultures are the
+// same size and close to 1 if one culture has almost every cell. A single
+// culture (or no population) has a Gini coefficient of 0
+func (s *Sim) Gini() float64 {
+	counts := make(map[int]int)
+	for _, c := range s.Cells {
+		if c.getRGB() != Empty {
+			counts[c.getRGB()]++
+		}
+	}
+	return gini(counts)
+}
+
+// Gini coefficient of the counts of values, the mean absolute difference
+// between every 2 counts divided by twice their mean
+func gini(counts map[int]int) float64 {
+	sizes := make([]int, 0, len(counts))
+	for _, count := range counts {
+		sizes = append(sizes, count)
+	}
+	if len(sizes) < 2 {
+		return 0
+	}
+	// over the sizes in ascending order, the sum of the differences is the
+	// sum of every size weighted by its rank
+	sort.Ints(sizes)
+	var weighted, total float64
+	for i, size := range sizes {
+		weighted += float64(2*i-len(sizes)+1) * float64(size)
+		total += float64(size)
+	}
+	return weighted / (float64(len(sizes)) * total)
+}
+
+// FeatureEntropy is the Shannon entropy, in bits, of the distribution of the
+// traits of each feature over the populated cells, one for every feature. A
+// feature that every culture shares the trait of has an entropy of 0
+func (s *Sim) FeatureEntropy() []float64 {
+	entropies := make([]float64, s.Features)
+	for i := range entropies {
+		entropies[i] = s.featureEntropy(uint(i))
+	}
+	return entropies
+}
+
+// Shannon entropy, in bits, of the distribution of the traits of the feature
+// at position pos over the populated cells, added up in the fixed order of
+// entropy so that the same grid always has the same feature entropies
+func (s *Sim) featureEntropy(pos uint) float64 {
+	counts := make(map[int]int)
+	var total int
+	for _, c := range s.Cells {
+		if c.getRGB() != Empty {
+			counts[s.Extract(c.getRGB(), pos)]++
+			total++
+		}
+	}
+	return entropy(counts, total)
+}
+
+// Shannon entropy, in bits, of the distribution of the counts of values that
+// add up to total. The terms are added in the order of the sorted counts, not
+// the random order of the map, so that the same grid always has exactly the
+// same entropy
+func entropy(counts map[int]int, total int) (h float64) {
+	sorted := make([]int, 0, len(counts))
+	for _, count := range counts {
+		sorted = append(sorted, count)
+	}
+	sort.Ints(sorted)
+	for _, count := range sorted {
+		p := float64(count) / float64(total)
+		h -= p * math.Log2(p)
+	}
+	return
+}
+
+// find the distance of 2 numbers at position pos. With the manhattan
+// distance traits are ordered and the distance is the difference between
+// them, with the hamming distance traits are either the same (0) or different (1)
+func (s *Sim) traitDistance(n1, n2 int, pos uint) int {
+	if s.Distance == "hamming" {
+		if s.Extract(n1, pos) != s.Extract(n2, pos) {
+			return 1
+		}
+		return 0
+	}
+	d := s.Extract(n1, pos) - s.Extract(n2, pos)
+	if d < 0 {
+		return d * -1
+	}
+	return d
+}
+
+// Extract the trait for 1 feature
+func (s *Sim) Extract(n int, pos uint) int {
+	if s.wide != nil {
+		return s.wide.trait(n, pos)
+	}
+	return (n >> (s.traitBits * pos)) & s.traitMask
+}
+
+// Replace the trait in 1 feature. The mask of the feature clears its bits and
+// they are then set to the replacement, which is cut to the bits of a trait so
+// that it can't spill into the other features. A wide culture is replaced by
+// the culture of its traits with the replacement in the feature
+func (s *Sim) Replace(n, replacement int, pos uint) int {
+	if s.wide != nil {
+		traits := s.wide.copyTraits(n)
+		if traits[pos] == replacement {
+			return n
+		}
+		traits[pos] = replacement
+		return s.wide.culture(traits)
+	}
+	i1 := n & s.masks[pos]
+	mask2 := (replacement & s.traitMask) << (s.traitBits * pos)
+	return i1 | mask2
+}
@@ -0,0 +1,714 @@
+package culture
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// the parameters of a small grid with the defaults of the command line
+func testParams(width int) Params {
+	params := DefaultParams()
+	params.Width = width
+	return params
+}
+
+// a simulation of the parameters and seed, populated and logging the metrics
+func newTestSim(t *testing.T, params Params, seed int64, metrics string) *Sim {
+	t.Helper()
+	s, err := NewSim(params, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SelectMetrics(metrics); err != nil {
+		t.Fatal(err)
+	}
+	s.CreatePopulation()
+	return s
+}
+
+// run the ticks of the simulation, logging the metrics of every tick
+func runTicks(t *testing.T, s *Sim, ticks int) {
+	t.Helper()
+	for i := 0; i < ticks; i++ {
+		result, err := s.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.LogMetrics(result.Metrics)
+	}
+}
+
+// the cultures of the cells of the grid
+func cultures(s *Sim) []int {
+	c := make([]int, len(s.Cells))
+	for n := range s.Cells {
+		c[n] = s.Cells[n].getRGB()
+	}
+	return c
+}
+
+// whether the two grids have the same cultures
+func sameCultures(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// a grid of 2 cells next to each other with the cultures
+func pairSim(t *testing.T, features int, a, b int) *Sim {
+	t.Helper()
+	params := testParams(2)
+	params.Height = 1
+	params.Features = features
+	s := newTestSim(t, params, 1, "unique")
+	s.setRGB(0, a)
+	s.setRGB(1, b)
+	return s
+}
+
+func TestExchangeCountsRepeatedChanges(t *testing.T) {
+	// with a single feature every exchange copies the trait that differs
+	s := pairSim(t, 1, 0, 1)
+	changes := 0
+	for i := 0; i < 200; i++ {
+		s.setRGB(0, 0)
+		s.setRGB(1, 1)
+		exchanged, err := s.exchange(0, 1, s.rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exchanged {
+			changes++
+		}
+	}
+	counted := s.Cells[0].Changes + s.Cells[1].Changes
+	if counted != changes {
+		t.Errorf("the cells counted %d changes, but there were %d exchanges of the trait that differs", counted, changes)
+	}
+	// the exchange happens with a probability of 14/15, half the time into each cell
+	if s.Cells[0].Changes < 60 || s.Cells[1].Changes < 60 {
+		t.Errorf("the cells changed %d and %d times in 200 exchanges, want about 93 each", s.Cells[0].Changes, s.Cells[1].Changes)
+	}
+}
+
+func TestExchangeOfSharedFeatureChangesNothing(t *testing.T) {
+	// the cultures share every feature but the first
+	s := pairSim(t, 6, 0x000000, 0x000001)
+	var exchanges, changes int
+	for i := 0; i < 600; i++ {
+		s.setRGB(0, 0x000000)
+		s.setRGB(1, 0x000001)
+		before := s.Cells[0].Changes + s.Cells[1].Changes
+		exchanged, err := s.exchange(0, 1, s.rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		moved := s.Cells[0].getRGB() != 0x000000 || s.Cells[1].getRGB() != 0x000001
+		if moved && !exchanged {
+			t.Fatal("the cultures changed without an exchange")
+		}
+		if counted := s.Cells[0].Changes + s.Cells[1].Changes - before; moved && counted != 1 || !moved && counted != 0 {
+			t.Fatalf("exchange counted %d changes, but the cultures changed is %v", counted, moved)
+		}
+		if exchanged {
+			exchanges++
+		}
+		if moved {
+			changes++
+		}
+	}
+	// an exchange of a feature the cultures share is still an exchange, 5
+	// times out of 6
+	if changes == 0 || exchanges < 4*changes {
+		t.Errorf("%d exchanges of which %d changed a trait, want about 6 exchanges for every change", exchanges, changes)
+	}
+}
+
+func TestAdoptMediaOfSharedFeatureChangesNothing(t *testing.T) {
+	params := testParams(1)
+	params.MediaCulture = []int{1, 0, 0, 0, 0, 0}
+	params.MediaStrength = 1
+	s := newTestSim(t, params, 1, "unique")
+	var exchanges, changes int
+	for i := 0; i < 600; i++ {
+		s.setRGB(0, 0x000000)
+		before := s.Cells[0].Changes
+		exchanged, err := s.adoptMedia(0, s.rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		moved := s.Cells[0].getRGB() != 0x000000
+		if moved && !exchanged {
+			t.Fatal("the culture changed without an exchange with the media")
+		}
+		if counted := s.Cells[0].Changes - before; moved && counted != 1 || !moved && counted != 0 {
+			t.Fatalf("adoptMedia counted %d changes, but the culture changed is %v", counted, moved)
+		}
+		if exchanged {
+			exchanges++
+		}
+		if moved {
+			changes++
+		}
+	}
+	if changes == 0 || exchanges < 4*changes {
+		t.Errorf("%d exchanges with the media of which %d changed a trait, want about 6 exchanges for every change", exchanges, changes)
+	}
+}
+
+func TestCultureDiffCountsEveryFeature(t *testing.T) {
+	for _, distance := range []string{"manhattan", "hamming"} {
+		params := testParams(2)
+		params.Distance = distance
+		s := newTestSim(t, params, 1, "unique")
+		for pos := 0; pos < s.Features; pos++ {
+			// the cultures differ only in the trait of the feature, 3 apart
+			c := s.Replace(0x123456, 0x9, uint(pos))
+			other := s.Replace(c, 0xC, uint(pos))
+			want := 3
+			if distance == "hamming" {
+				want = 1
+			}
+			if d := s.CultureDiff(c, other); d != want {
+				t.Errorf("%s diff of %06X and %06X, differing in feature %d, is %d, want %d", distance, c, other, pos, d, want)
+			}
+			if d := s.FeatureDistance(c, other); d != 1 {
+				t.Errorf("feature distance of %06X and %06X, differing in feature %d, is %d, want 1", c, other, pos, d)
+			}
+		}
+	}
+}
+
+func TestCultureDiffIdenticalAndOpposite(t *testing.T) {
+	tests := []struct {
+		features, traits int
+	}{
+		{6, 16},
+		{3, 8},
+		{10, 2},
+	}
+	for _, tt := range tests {
+		params := testParams(2)
+		params.Features, params.Traits = tt.features, tt.traits
+		s := newTestSim(t, params, 1, "unique")
+		// the lowest and the highest trait of every feature
+		var lowest, highest int
+		for pos := 0; pos < s.Features; pos++ {
+			highest = s.Replace(highest, tt.traits-1, uint(pos))
+		}
+		for _, c := range []int{lowest, highest, s.randomCulture()} {
+			if d := s.CultureDiff(c, c); d != 0 {
+				t.Errorf("%dx%d: diff of %X with itself is %d, want 0", tt.features, tt.traits, c, d)
+			}
+			if d := s.FeatureDistance(c, c); d != 0 {
+				t.Errorf("%dx%d: feature distance of %X with itself is %d, want 0", tt.features, tt.traits, c, d)
+			}
+		}
+		if d, want := s.CultureDiff(lowest, highest), tt.features*(tt.traits-1); d != want {
+			t.Errorf("%dx%d: diff of the lowest and highest cultures is %d, want the maximum %d", tt.features, tt.traits, d, want)
+		}
+		if d := s.FeatureDistance(lowest, highest); d != tt.features {
+			t.Errorf("%dx%d: feature distance of the lowest and highest cultures is %d, want %d", tt.features, tt.traits, d, tt.features)
+		}
+	}
+}
+
+func TestSetRGBRoundTrip(t *testing.T) {
+	s := newTestSim(t, testParams(2), 1, "unique")
+	check := func(c int) {
+		s.setRGB(0, c)
+		if got := s.Cells[0].getRGB(); got != c {
+			t.Errorf("setRGB(%06X) then getRGB() is %06X", c, got)
+		}
+		// with 6 features of 16 traits the culture is also its own color
+		got, err := s.ColorCulture(s.CultureColor(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != Empty && got != c {
+			t.Errorf("the color of culture %06X is turned back into culture %06X", c, got)
+		}
+	}
+	check(Empty)
+	check(0x000000)
+	check(0xFFFFFF)
+	for i := 0; i < 1000; i++ {
+		check(s.randomCulture())
+	}
+}
+
+func TestExchangeGoesBothWays(t *testing.T) {
+	s := newTestSim(t, testParams(12), 42, "unique")
+	var exchanges, reversed int
+	s.Record = func(in Interaction) {
+		// only the exchanges that copied a trait between neighbours have a
+		// direction
+		if in.Neighbour < 0 || in.Feature < 0 || !in.Fired {
+			return
+		}
+		exchanges++
+		if in.Reversed {
+			reversed++
+		}
+	}
+	runTicks(t, s, 50)
+	if exchanges < 1000 {
+		t.Fatalf("only %d exchanges to count the directions of", exchanges)
+	}
+	if share := float64(reversed) / float64(exchanges); share < 0.45 || share > 0.55 {
+		t.Errorf("%d of %d exchanges copied from the neighbour into the cell, a share of %.3f instead of about 0.5", reversed, exchanges, share)
+	}
+}
+
+func TestExchangeProbabilityOversizedDistance(t *testing.T) {
+	s := newTestSim(t, testParams(2), 1, "unique")
+	for _, d := range []int{0, s.maxDiff / 2, s.maxDiff} {
+		if _, err := s.exchangeProbability(0, 0, d); err != nil {
+			t.Errorf("distance %d of at most %d: %v", d, s.maxDiff, err)
+		}
+	}
+	p, err := s.exchangeProbability(0, 0, s.maxDiff+1)
+	if err == nil {
+		t.Errorf("distance %d beyond the maximum %d isn't reported", s.maxDiff+1, s.maxDiff)
+	}
+	if p != 0 {
+		t.Errorf("distance %d beyond the maximum %d has a probability of %v, want 0", s.maxDiff+1, s.maxDiff, p)
+	}
+}
+
+func TestOversizedDistanceGuard(t *testing.T) {
+	saved := log.Writer()
+	defer log.SetOutput(saved)
+	for _, strict := range []bool{false, true} {
+		var logged bytes.Buffer
+		log.SetOutput(&logged)
+		s := pairSim(t, 6, 0x000000, 0xFFFFFF)
+		s.Strict = strict
+		// a misconfigured maximum below the distance of the cultures
+		s.maxDiff = 10
+		_, err := s.Step()
+		if strict && err == nil {
+			t.Error("strict: the oversized distance didn't stop the simulation")
+		}
+		if !strict && (err != nil || !strings.Contains(logged.String(), "exceeds the maximum distance")) {
+			t.Errorf("the oversized distance wasn't logged, the step returned %v and logged %q", err, logged.String())
+		}
+		if got := cultures(s); got[0] != 0x000000 || got[1] != 0xFFFFFF {
+			t.Errorf("strict %v: cultures an oversized distance apart exchanged into %06X and %06X", strict, got[0], got[1])
+		}
+	}
+}
+
+func TestBudgetStopsInitiating(t *testing.T) {
+	s := pairSim(t, 6, 0x123456, 0x123456)
+	s.InteractionCost, s.Budget = 1, 3
+	initiated := map[int]int{}
+	s.Record = func(in Interaction) { initiated[in.Cell]++ }
+	for i := 0; i < 10; i++ {
+		if _, err := s.interact(0, s.rng); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the cell initiates until its cost is over the budget, 4 interactions
+	// costing 1 each for a budget of 3
+	if initiated[0] != 4 {
+		t.Errorf("the cell initiated %d interactions on a budget of 3 at a cost of 1, want 4", initiated[0])
+	}
+	if s.Cells[0].Cost != 4 {
+		t.Errorf("the cell spent %v on interactions, want 4", s.Cells[0].Cost)
+	}
+	// the exhausted cell is still interacted with by its neighbour
+	if _, err := s.interact(1, s.rng); err != nil {
+		t.Fatal(err)
+	}
+	if initiated[1] != 1 {
+		t.Errorf("the neighbour of the exhausted cell initiated %d interactions, want 1", initiated[1])
+	}
+}
+
+func TestNoBudget(t *testing.T) {
+	s := pairSim(t, 6, 0x123456, 0x123456)
+	s.InteractionCost = 1
+	initiated := 0
+	s.Record = func(in Interaction) { initiated++ }
+	for i := 0; i < 10; i++ {
+		if _, err := s.interact(0, s.rng); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if initiated != 10 || s.Cells[0].Cost != 10 {
+		t.Errorf("without a budget the cell initiated %d of 10 interactions and spent %v, want 10 and 10", initiated, s.Cells[0].Cost)
+	}
+}
+
+// the orders in which the centre cell of a 3 by 3 grid of the same culture
+// interacts with its neighbours, over the interactions, counted by order
+func neighbourOrders(t *testing.T, shuffle bool, interactions int) map[string]int {
+	t.Helper()
+	params := testParams(3)
+	params.Init = "stripes"
+	params.InitCultures = 1
+	params.ShuffleNeighbours = shuffle
+	s := newTestSim(t, params, 7, "unique")
+	orders := make(map[string]int)
+	var order []int
+	s.Record = func(in Interaction) { order = append(order, in.Neighbour) }
+	for i := 0; i < interactions; i++ {
+		order = order[:0]
+		if _, err := s.interact(4, s.rng); err != nil {
+			t.Fatal(err)
+		}
+		orders[fmt.Sprint(order)]++
+	}
+	return orders
+}
+
+func TestShuffleNeighboursOrders(t *testing.T) {
+	const interactions = 24000
+	orders := neighbourOrders(t, true, interactions)
+	// every one of the 24 orders of 4 neighbours about as often
+	if len(orders) != 24 {
+		t.Fatalf("the neighbours are shuffled into %d orders, want 24", len(orders))
+	}
+	for order, count := range orders {
+		if count < 800 || count > 1200 {
+			t.Errorf("the neighbours are shuffled into the order %s %d times in %d interactions, want about 1000", order, count, interactions)
+		}
+	}
+}
+
+func TestFixedNeighboursOrder(t *testing.T) {
+	if orders := neighbourOrders(t, false, 100); len(orders) != 1 {
+		t.Errorf("without shuffling the neighbours are interacted with in %d orders, want 1", len(orders))
+	}
+}
+
+func TestTinyGrids(t *testing.T) {
+	all := "distance,change,unique,domains,largest,entropy,gini,boundaries,active,media,drift,migrations,turnover,colonies,correlation,feature-entropy"
+	tests := []struct {
+		width, height int
+		coverage      float64
+		neighbourhood string
+	}{
+		{1, 1, 1, "vonneumann"},
+		{1, 1, 1, "moore"},
+		{2, 2, 1, "vonneumann"},
+		{2, 2, 1, "moore"},
+		{2, 2, 0.5, "vonneumann"},
+		{2, 2, 0, "vonneumann"},
+		{1, 2, 1, "vonneumann"},
+	}
+	for _, tt := range tests {
+		params := testParams(tt.width)
+		params.Height, params.Coverage, params.Neighbourhood = tt.height, tt.coverage, tt.neighbourhood
+		params.Drift, params.Migration, params.Turnover = 0.1, 0.1, 0.1
+		s := newTestSim(t, params, 1, all)
+		runTicks(t, s, 20)
+		for i, m := range s.Metrics {
+			for _, v := range s.MetricData[i][1:] {
+				if v == "NaN" || v == "+Inf" || v == "-Inf" {
+					t.Errorf("%dx%d grid with coverage %v and a %s neighbourhood: %s is %s", tt.width, tt.height, tt.coverage, tt.neighbourhood, m.Name(), v)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestGeneratedMasks(t *testing.T) {
+	// the masks of 6 features of 16 traits are those the culture integer
+	// has always been packed with
+	s := newTestSim(t, testParams(2), 1, "unique")
+	want := []int{0xFFFFF0, 0xFFFF0F, 0xFFF0FF, 0xFF0FFF, 0xF0FFFF, 0x0FFFFF}
+	if fmt.Sprintf("%X", s.masks) != fmt.Sprintf("%X", want) {
+		t.Errorf("the masks of 6 features of 16 traits are %X, want %X", s.masks, want)
+	}
+	tests := []struct {
+		features, traits int
+		bits             uint
+	}{
+		{6, 16, 4},
+		{3, 8, 3},
+		{10, 2, 1},
+		{4, 10, 4},
+		{12, 32, 5},
+	}
+	for _, tt := range tests {
+		params := testParams(2)
+		params.Features, params.Traits = tt.features, tt.traits
+		s := newTestSim(t, params, 1, "unique")
+		if len(s.masks) != tt.features || s.traitBits != tt.bits {
+			t.Fatalf("%dx%d: %d masks of traits of %d bits, want %d of %d bits", tt.features, tt.traits, len(s.masks), s.traitBits, tt.features, tt.bits)
+		}
+		all := 1<<(uint(tt.features)*tt.bits) - 1
+		for pos, mask := range s.masks {
+			// the mask clears the bits of its feature and keeps all the others
+			if cleared := all &^ mask; cleared != (1<<tt.bits-1)<<(tt.bits*uint(pos)) {
+				t.Errorf("%dx%d: the mask of feature %d is %X, which clears the bits %X", tt.features, tt.traits, pos, mask, cleared)
+			}
+		}
+	}
+}
+
+func TestReplaceNonzeroTrait(t *testing.T) {
+	s := newTestSim(t, testParams(2), 1, "unique")
+	tests := []struct {
+		culture, trait int
+		pos            uint
+		want           int
+	}{
+		{0x1A2B3C, 0x5, 2, 0x1A253C},
+		{0x1A2B3C, 0x0, 0, 0x1A2B30},
+		{0x1A2B3C, 0xF, 5, 0xFA2B3C},
+		// XOR of the trait into the unmasked culture would give the trait 0x6
+		{0xFFFFFF, 0x9, 3, 0xFF9FFF},
+	}
+	for _, tt := range tests {
+		if got := s.Replace(tt.culture, tt.trait, tt.pos); got != tt.want {
+			t.Errorf("replacing feature %d of %06X with %X is %06X, want %06X", tt.pos, tt.culture, tt.trait, got, tt.want)
+		}
+	}
+}
+
+func TestReplaceMatrix(t *testing.T) {
+	s := newTestSim(t, testParams(2), 1, "unique")
+	for _, c := range []int{0x000000, 0xFFFFFF, 0x1A2B3C, 0xF0F0F0} {
+		for pos := uint(0); pos < uint(s.Features); pos++ {
+			for _, trait := range []int{0, 15} {
+				got := s.Replace(c, trait, pos)
+				for i := uint(0); i < uint(s.Features); i++ {
+					want := s.Extract(c, i)
+					if i == pos {
+						want = trait
+					}
+					if s.Extract(got, i) != want {
+						t.Errorf("replacing feature %d of %06X with %X gives %06X, with the trait %X in feature %d instead of %X", pos, c, trait, got, s.Extract(got, i), i, want)
+					}
+				}
+				if got&^0xFFFFFF != 0 {
+					t.Errorf("replacing feature %d of %06X with %X spills into the bits beyond the features, %X", pos, c, trait, got)
+				}
+			}
+		}
+	}
+}
+
+func TestWideCultures(t *testing.T) {
+	// cultures too wide to pack into a culture integer
+	tests := []struct{ features, traits int }{
+		{10, 100},
+		{8, 256},
+		{20, 16},
+	}
+	for _, tt := range tests {
+		params := testParams(8)
+		params.Features, params.Traits = tt.features, tt.traits
+		s := newTestSim(t, params, 1, "distance,unique,entropy")
+		if s.wide == nil {
+			t.Fatalf("%dx%d: the cultures are packed", tt.features, tt.traits)
+		}
+		runTicks(t, s, 20)
+		for n, c := range s.Cells {
+			traits := s.CultureTraits(c.Culture)
+			if len(traits) != tt.features {
+				t.Fatalf("%dx%d: cell %d has %d traits", tt.features, tt.traits, n, len(traits))
+			}
+			for i, trait := range traits {
+				if trait < 0 || trait >= tt.traits || s.Extract(c.Culture, uint(i)) != trait {
+					t.Fatalf("%dx%d: cell %d has the trait %d in feature %d", tt.features, tt.traits, n, trait, i)
+				}
+			}
+			if got, err := s.ParseCulture(s.FormatCulture(c.Culture)); err != nil || got != c.Culture {
+				t.Fatalf("%dx%d: culture %s of cell %d reads back as %d, %v", tt.features, tt.traits, s.FormatCulture(c.Culture), n, got, err)
+			}
+		}
+
+		// replacing a trait gives the culture of the replaced traits, and
+		// replacing it back the culture it started from
+		c := s.Cells[0].Culture
+		last := uint(tt.features - 1)
+		trait := s.Extract(c, last)
+		replaced := s.Replace(c, (trait+1)%tt.traits, last)
+		if replaced == c || s.CultureDiff(c, replaced) != 1 {
+			t.Errorf("%dx%d: replacing the last trait gives a culture %d apart", tt.features, tt.traits, s.CultureDiff(c, replaced))
+		}
+		if back := s.Replace(replaced, trait, last); back != c {
+			t.Errorf("%dx%d: replacing the trait back gives culture %d, want %d", tt.features, tt.traits, back, c)
+		}
+		if _, err := s.Culture(make([]int, tt.features-1)); err == nil {
+			t.Errorf("%dx%d: a culture short of a trait is valid", tt.features, tt.traits)
+		}
+	}
+}
+
+func TestParseTraits(t *testing.T) {
+	tests := []struct {
+		spec             string
+		features, traits int
+		want             string
+	}{
+		{"0x1A2B3C", 6, 16, "[12 3 11 2 10 1]"},
+		{"12:3:11:2:10:1", 6, 16, "[12 3 11 2 10 1]"},
+		{"0x1000000", 6, 16, ""},
+		{"0x1", 10, 100, ""},
+		{"5:5:5:5:5:5:5:5:5:99", 10, 100, "[5 5 5 5 5 5 5 5 5 99]"},
+		{"5:x", 2, 100, ""},
+	}
+	for _, tt := range tests {
+		traits, err := ParseTraits(tt.spec, tt.features, tt.traits)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s of %dx%d reads as %v, want an error", tt.spec, tt.features, tt.traits, traits)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprint(traits) != tt.want {
+			t.Errorf("%s of %dx%d reads as %v, %v, want %s", tt.spec, tt.features, tt.traits, traits, err, tt.want)
+		}
+	}
+}
+
+func TestWideMediaCulture(t *testing.T) {
+	params := testParams(6)
+	params.Features, params.Traits = 10, 100
+	params.MediaCulture = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	params.MediaStrength = 1
+	s := newTestSim(t, params, 1, "unique")
+	runTicks(t, s, 200)
+	if !s.Monoculture() {
+		t.Fatal("the mass media alone didn't turn the grid into a monoculture")
+	}
+	if got := fmt.Sprint(s.CultureTraits(s.Cells[0].Culture)); got != fmt.Sprint(params.MediaCulture) {
+		t.Errorf("the grid took the culture %s, not that of the media %v", got, params.MediaCulture)
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	// run with -race, the workers share the cells of the grid and the table
+	// of the wide cultures
+	tests := []struct{ features, traits int }{
+		{6, 16},
+		{10, 100},
+	}
+	for _, tt := range tests {
+		params := testParams(12)
+		params.Features, params.Traits = tt.features, tt.traits
+		params.Workers = 4
+		s := newTestSim(t, params, 1, "distance,change,unique,largest,entropy")
+		if len(s.workerRngs) != 4 {
+			t.Fatalf("%dx%d: the interactions are split across %d workers, want 4", tt.features, tt.traits, len(s.workerRngs))
+		}
+		runTicks(t, s, 50)
+		for n, c := range s.Cells {
+			traits := s.CultureTraits(c.Culture)
+			if len(traits) != tt.features {
+				t.Fatalf("%dx%d: cell %d has %d traits", tt.features, tt.traits, n, len(traits))
+			}
+			for i, trait := range traits {
+				if trait < 0 || trait >= tt.traits {
+					t.Fatalf("%dx%d: cell %d has the trait %d in feature %d", tt.features, tt.traits, n, trait, i)
+				}
+			}
+		}
+		cells := float64(len(s.Cells))
+		// every metric is within what the grid of 12 by 12 cells can reach,
+		// with every link between neighbours as far apart as possible, every
+		// one of the 100 interactions of a tick exchanging with all 4
+		// neighbours and every cell of its own culture
+		ranges := []struct{ min, max float64 }{
+			{0, 4 * 12 * float64(tt.features)},
+			{0, 4 * 100.0 / 12},
+			{1, cells},
+			{1 / cells, 1},
+			{0, math.Log2(cells) + 1e-9},
+		}
+		for i, r := range ranges {
+			data := s.MetricData[i]
+			if len(data) != 51 {
+				t.Fatalf("%dx%d: %s logged %d ticks, want 50", tt.features, tt.traits, data[0], len(data)-1)
+			}
+			for tick, field := range data[1:] {
+				v, err := strconv.ParseFloat(field, 64)
+				if err != nil || v < r.min || v > r.max {
+					t.Errorf("%dx%d: %s is %s at tick %d, want between %v and %v", tt.features, tt.traits, data[0], field, tick, r.min, r.max)
+				}
+			}
+		}
+	}
+}
+
+func TestDistinctCulturesExcludesEmpty(t *testing.T) {
+	s := newTestSim(t, testParams(3), 1, "unique")
+	// culture 000000 is a culture like any other, unlike an empty cell
+	grid := []int{Empty, 0x000000, 0x123456, Empty, 0x000000, 0xABCDEF, Empty, 0x123456, Empty}
+	for n, c := range grid {
+		s.setRGB(n, c)
+	}
+	if got := s.DistinctCultures(); got != 3 {
+		t.Errorf("%d distinct cultures on a grid of 3 cultures and 4 empty cells, want 3", got)
+	}
+	for n := range grid {
+		s.setRGB(n, Empty)
+	}
+	if got := s.DistinctCultures(); got != 0 {
+		t.Errorf("%d distinct cultures on an empty grid, want 0", got)
+	}
+	if s.Monoculture() {
+		t.Error("an empty grid is a monoculture")
+	}
+}
+
+func TestEntropyReproducible(t *testing.T) {
+	// the entropy of a grid of many cultures, which a sum in the order of a
+	// map would round differently from run to run
+	runs := make([]*Sim, 2)
+	for i := range runs {
+		runs[i] = newTestSim(t, testParams(20), 9, "entropy")
+		runTicks(t, runs[i], 10)
+	}
+	a, b := runs[0].MetricData[0], runs[1].MetricData[0]
+	if fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Errorf("two runs of the same seed logged the entropies %v and %v", a, b)
+	}
+	for i := 0; i < 50; i++ {
+		if h := runs[0].Entropy(); h != runs[1].Entropy() {
+			t.Fatalf("the same grid has the entropies %v and %v", h, runs[1].Entropy())
+		}
+	}
+}
+
+func TestFeatureEntropyReproducible(t *testing.T) {
+	// many traits for many terms in the entropy of every feature
+	params := testParams(20)
+	params.Traits = 256
+	runs := make([]*Sim, 2)
+	for i := range runs {
+		runs[i] = newTestSim(t, params, 9, "feature-entropy")
+		runTicks(t, runs[i], 10)
+	}
+	for pos := 0; pos < params.Features; pos++ {
+		a, b := runs[0].MetricData[pos], runs[1].MetricData[pos]
+		if a[0] != fmt.Sprintf("entropy-%d", pos) {
+			t.Fatalf("metric %d logged is %s, want entropy-%d", pos, a[0], pos)
+		}
+		if fmt.Sprint(a) != fmt.Sprint(b) {
+			t.Errorf("two runs of the same seed logged the entropies of feature %d %v and %v", pos, a, b)
+		}
+		for i := 0; i < 20; i++ {
+			if h := runs[0].featureEntropy(uint(pos)); h != runs[1].featureEntropy(uint(pos)) {
+				t.Fatalf("the same grid has the entropies of feature %d %v and %v", pos, h, runs[1].featureEntropy(uint(pos)))
+			}
+		}
+	}
+}
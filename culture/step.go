@@ -0,0 +1,56 @@
+package culture
+
+// StepResult is what happened in one tick of the simulation
+type StepResult struct {
+	Exchanges      int       // number of cultural exchanges
+	MediaExchanges int       // number of cultural exchanges with the mass media
+	Colonies       int       // number of empty cells colonized
+	Drifts         int       // number of cells that drifted
+	Migrations     int       // number of cultures that migrated
+	Turnovers      int       // number of cells that died and were reborn
+	Metrics        []float64 // values of the selected metrics once the tick is done, in the order of the metrics
+}
+
+// Step runs one tick of the simulation, the interactions of the tick followed
+// by the drift, migration and turnover that are enabled, and measures the
+// selected metrics once they are done. The counts are reset at the start of
+// the tick, so that afterwards they are the counts of the tick. With a single
+// worker the ticks of a simulation are reproducible from its seed
+func (s *Sim) Step() (StepResult, error) {
+	return s.step(s.RunInteractions)
+}
+
+// StepReplay runs one tick of the simulation like Step, but applies the
+// recorded interactions of the tick instead of running them
+func (s *Sim) StepReplay(interactions []Interaction) (StepResult, error) {
+	return s.step(func() error { return s.Replay(interactions) })
+}
+
+// run a tick with the interactions made by interact
+func (s *Sim) step(interact func() error) (StepResult, error) {
+	s.Exchanges, s.MediaExchanges, s.Colonies, s.Drifts, s.Migrations, s.Turnovers = 0, 0, 0, 0, 0, 0
+	if err := interact(); err != nil {
+		return StepResult{}, err
+	}
+	// cultures also innovate on their own
+	if s.Drift > 0 || s.driftRates != nil {
+		s.ApplyDrift()
+	}
+	// and move around
+	if s.Migration > 0 {
+		s.ApplyMigration()
+	}
+	// and are replaced by the next generation
+	if s.Turnover > 0 {
+		s.ApplyTurnover()
+	}
+	return StepResult{
+		Exchanges:      s.Exchanges,
+		MediaExchanges: s.MediaExchanges,
+		Colonies:       s.Colonies,
+		Drifts:         s.Drifts,
+		Migrations:     s.Migrations,
+		Turnovers:      s.Turnovers,
+		Metrics:        s.MeasureMetrics(),
+	}, nil
+}
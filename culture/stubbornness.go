@@ -0,0 +1,77 @@
+package culture
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// parse the distribution of the stubbornness of the cells, a probability
+// between 0 and 1 of a cell resisting a change to its culture. The
+// distribution is either a single value for every cell, uniform:min:max for
+// values spread evenly between min and max, or fraction:p:v for a fraction p
+// of stubborn cells with a stubbornness of v among cells that aren't stubborn.
+// Returns nil for no stubbornness
+func parseStubbornness(spec string) (func(rng *rand.Rand) float64, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	parts := strings.Split(spec, ":")
+	values := make([]float64, len(parts)-1)
+	for i, part := range parts[1:] {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 || v > 1 {
+			return nil, fmt.Errorf("stubbornness %q must have values between 0 and 1", spec)
+		}
+		values[i] = v
+	}
+	switch {
+	case len(parts) == 1:
+		v, err := strconv.ParseFloat(spec, 64)
+		if err != nil || v < 0 || v > 1 {
+			return nil, fmt.Errorf("stubbornness %q must be between 0 and 1", spec)
+		}
+		return func(*rand.Rand) float64 { return v }, nil
+	case parts[0] == "uniform" && len(values) == 2 && values[0] <= values[1]:
+		return func(rng *rand.Rand) float64 { return values[0] + rng.Float64()*(values[1]-values[0]) }, nil
+	case parts[0] == "fraction" && len(values) == 2:
+		return func(rng *rand.Rand) float64 {
+			if rng.Float64() < values[0] {
+				return values[1]
+			}
+			return 0
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown stubbornness %q, must be a value, uniform:min:max or fraction:p:v", spec)
+}
+
+// check if the cell at index n resists a change to its culture, with the
+// probability of its stubbornness
+func (s *Sim) resists(n int, rng *rand.Rand) bool {
+	return s.Cells[n].Stubbornness > 0 && rng.Float64() < s.Cells[n].Stubbornness
+}
+
+// StubbornnessCorrelation is the Pearson correlation between the stubbornness
+// of the populated cells and the size of the cultural domain they are in,
+// positive when stubborn cells anchor large domains. It is 0 if either
+// doesn't vary across the cells
+func (s *Sim) StubbornnessCorrelation() float64 {
+	labels, sizes := s.domains()
+	var n, sx, sy, sxx, syy, sxy float64
+	for c, label := range labels {
+		if label < 0 {
+			continue
+		}
+		x, y := s.Cells[c].Stubbornness, float64(sizes[label])
+		n++
+		sx, sy = sx+x, sy+y
+		sxx, syy, sxy = sxx+x*x, syy+y*y, sxy+x*y
+	}
+	vx, vy := n*sxx-sx*sx, n*syy-sy*sy
+	if n == 0 || vx <= 0 || vy <= 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / math.Sqrt(vx*vy)
+}
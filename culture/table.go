@@ -0,0 +1,89 @@
+package culture
+
+import (
+	"strconv"
+	"sync"
+)
+
+// the cultures too wide to pack into an integer, each kept as its traits, an
+// []int with a trait in [0, traits) for every feature. The culture integer of
+// such a culture is the index of its traits in the table, and the same traits
+// always get the same culture integer, so that cultures still compare, and
+// key maps, as integers. Cultures are added as they appear and never removed
+type cultureTable struct {
+	mu     sync.RWMutex
+	traits [][]int
+	index  map[string]int // culture integer of the traits, by the key of the traits
+}
+
+// create an empty culture table
+func newCultureTable() *cultureTable {
+	return &cultureTable{index: make(map[string]int)}
+}
+
+// the key of the traits in the index of the table
+func traitsKey(traits []int) string {
+	b := make([]byte, 0, 3*len(traits))
+	for _, t := range traits {
+		b = strconv.AppendInt(b, int64(t), 36)
+		b = append(b, ':')
+	}
+	return string(b)
+}
+
+// the culture integer of the traits, adding them to the table if they are
+// new. The table keeps its own copy of the traits
+func (t *cultureTable) culture(traits []int) int {
+	key := traitsKey(traits)
+	t.mu.RLock()
+	c, ok := t.index[key]
+	t.mu.RUnlock()
+	if ok {
+		return c
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// another worker may have added the same traits in the meantime
+	if c, ok := t.index[key]; ok {
+		return c
+	}
+	c = len(t.traits)
+	t.traits = append(t.traits, append([]int(nil), traits...))
+	t.index[key] = c
+	return c
+}
+
+// the trait of the feature at position pos of the culture c
+func (t *cultureTable) trait(c int, pos uint) int {
+	t.mu.RLock()
+	trait := t.traits[c][pos]
+	t.mu.RUnlock()
+	return trait
+}
+
+// a copy of the traits of the culture c
+func (t *cultureTable) copyTraits(c int) []int {
+	t.mu.RLock()
+	traits := append([]int(nil), t.traits[c]...)
+	t.mu.RUnlock()
+	return traits
+}
+
+// the number of cultures in the table
+func (t *cultureTable) len() int {
+	t.mu.RLock()
+	n := len(t.traits)
+	t.mu.RUnlock()
+	return n
+}
+
+// a copy of the traits of every culture in the table, by culture integer
+func (t *cultureTable) all() [][]int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	all := make([][]int, len(t.traits))
+	for c, traits := range t.traits {
+		all[c] = append([]int(nil), traits...)
+	}
+	return all
+}
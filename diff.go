@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sausheong/culture_sim/culture"
+)
+
+// compare the grids saved by saveGrid in the files at pathA and pathB, cell
+// by cell, reporting the cells that differ and the number of cells that
+// disagree in each feature. Cells that are populated in one grid and empty in
+// the other differ but have no features to compare. The differing cells are
+// saved as an image if imagePath is not empty
+func diffGrids(sim *culture.Sim, pathA, pathB, imagePath string) error {
+	a, err := readGrid(sim, pathA)
+	if err != nil {
+		return err
+	}
+	b, err := readGrid(sim, pathB)
+	if err != nil {
+		return err
+	}
+
+	var cells, distance, emptied int
+	differ := make([]int, len(a))
+	features := make([]int, sim.Features)
+	for n := range a {
+		if a[n] == b[n] {
+			continue
+		}
+		cells++
+		differ[n] = 1
+		if a[n] == culture.Empty || b[n] == culture.Empty {
+			emptied++
+			continue
+		}
+		distance += sim.FeatureDistance(a[n], b[n])
+		for i := range features {
+			if sim.Extract(a[n], uint(i)) != sim.Extract(b[n], uint(i)) {
+				features[i]++
+			}
+		}
+	}
+
+	fmt.Printf("Cells that differ: %d/%d\n", cells, len(a))
+	fmt.Printf("Cells populated in only one grid: %d\n", emptied)
+	fmt.Printf("Total feature distance: %d\n", distance)
+	for i, count := range features {
+		fmt.Printf("Cells that differ in feature %d: %d\n", i, count)
+	}
+
+	if imagePath != "" {
+		sim.PopulateGrid(a)
+		saveImage(imagePath, drawHeatmap(sim.Width*culture.CELLSIZE+culture.CELLSIZE,
+			sim.Height*culture.CELLSIZE+culture.CELLSIZE, sim.Cells, differ))
+	}
+	return nil
+}
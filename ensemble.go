@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sausheong/culture_sim/culture"
+)
+
+// run an ensemble of simulations with the same parameters, the first seeded
+// with the seed and every other with the next seed, so that any run can be
+// rerun on its own. Every run lasts all the ticks, so that the logged metrics
+// of every tick can be averaged over the runs. The mean and standard deviation
+// of every metric are saved as the data log of the ensemble, with the tick at
+// which every run reached a monoculture
+func runEnsemble(ctx context.Context, params culture.Params, name string, runs int, injected []int) error {
+	started := time.Now()
+	// logged values of every run, of every metric, of every tick
+	values := make([][][]float64, runs)
+	// tick at which every run first reached a monoculture, -1 if it never did
+	monocultures := make([]int, runs)
+	var names []string
+	for r := 0; r < runs; r++ {
+		runSeed := *seed + int64(r)
+		fmt.Printf("Run %d/%d with seed %d\n", r+1, runs, runSeed)
+		sim, err := culture.NewSim(params, runSeed)
+		if err != nil {
+			return err
+		}
+		if err := sim.SelectMetrics(*metricNames); err != nil {
+			return err
+		}
+		if err := populate(sim); err != nil {
+			return err
+		}
+		if names == nil {
+			for _, m := range sim.Metrics {
+				names = append(names, m.Name())
+			}
+		}
+		values[r] = make([][]float64, len(names))
+
+		monocultures[r] = -1
+		err = runHeadless(ctx, sim, injected, func(t int, result culture.StepResult) bool {
+			if monocultures[r] < 0 && sim.Monoculture() {
+				monocultures[r] = t
+			}
+			if t >= *burnin {
+				for i, v := range result.Metrics {
+					values[r][i] = append(values[r][i], v)
+				}
+			}
+			return true
+		})
+		// a cancelled ensemble keeps the runs that completed
+		if err != nil && ctx.Err() != nil && r > 0 {
+			fmt.Printf("Ensemble cancelled after %d runs\n", r)
+			values, monocultures, runs = values[:r], monocultures[:r], r
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	ensemblefile, err := os.Create(filepath.Join(*outputDir, fmt.Sprintf("ensemble-%s.csv", name)))
+	if err != nil {
+		return err
+	}
+	defer ensemblefile.Close()
+	if err := writeMetadata(ensemblefile, started); err != nil {
+		return err
+	}
+	csvwriter := csv.NewWriter(ensemblefile)
+	samples := make([]float64, runs)
+	if len(names) > 0 {
+		_ = csvwriter.Write(tickRow(len(values[0][0])))
+	}
+	for i, name := range names {
+		means := []string{name + "-mean"}
+		deviations := []string{name + "-std"}
+		for t := range values[0][i] {
+			for r := range values {
+				samples[r] = values[r][i][t]
+			}
+			mean, std := meanStd(samples)
+			means = append(means, strconv.FormatFloat(mean, 'f', -1, 64))
+			deviations = append(deviations, strconv.FormatFloat(std, 'f', -1, 64))
+		}
+		_ = csvwriter.Write(means)
+		_ = csvwriter.Write(deviations)
+	}
+	_ = csvwriter.Write([]string{"seed", strconv.FormatInt(*seed, 10)})
+	_ = csvwriter.Write([]string{"runs", strconv.Itoa(runs)})
+	at := []string{"monoculture-at"}
+	var reached []float64
+	for _, t := range monocultures {
+		at = append(at, strconv.Itoa(t))
+		if t >= 0 {
+			reached = append(reached, float64(t))
+		}
+	}
+	_ = csvwriter.Write(at)
+	csvwriter.Flush()
+	fmt.Printf("Ensemble of %d runs written to ensemble-%s.csv\n", runs, name)
+	if len(reached) > 0 {
+		mean, std := meanStd(reached)
+		sort.Float64s(reached)
+		fmt.Printf("Monoculture reached in %d of %d runs, at tick %.1f ± %.1f (min %.0f, median %.0f, max %.0f)\n",
+			len(reached), runs, mean, std, reached[0], median(reached), reached[len(reached)-1])
+	} else {
+		fmt.Printf("Monoculture reached in none of the %d runs\n", runs)
+	}
+	return csvwriter.Error()
+}
+
+// run the simulation without a display for all the ticks, calling after with
+// the result of every tick once its changes are done. The simulation ends
+// early if after returns false, or with the error of the context if it is
+// cancelled
+func runHeadless(ctx context.Context, sim *culture.Sim, injected []int, after func(t int, result culture.StepResult) bool) error {
+	for t := 0; t < *numTicks; t++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if *injectAt > 0 && t == *injectAt {
+			c, err := sim.Culture(injected)
+			if err != nil {
+				return fmt.Errorf("invalid inject culture: %s", err)
+			}
+			sim.Inject(c, *injectRadius)
+		}
+		result, err := sim.Step()
+		if err != nil {
+			return err
+		}
+		if !after(t, result) {
+			break
+		}
+	}
+	return nil
+}
+
+// mean and sample standard deviation of the values, the deviation of a single
+// value is 0
+func meanStd(values []float64) (mean, std float64) {
+	n := float64(len(values))
+	for _, v := range values {
+		mean += v / n
+	}
+	if len(values) < 2 {
+		return
+	}
+	for _, v := range values {
+		d := v - mean
+		std += d * d / (n - 1)
+	}
+	return mean, math.Sqrt(std)
+}
+
+// median of the sorted values
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
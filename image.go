@@ -1,31 +1,392 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
 	"fmt"
 	"image"
+	"image/color"
+	"image/gif"
 	"image/png"
+	"math"
 	"os"
+	"sort"
 
 	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/sausheong/culture_sim/culture"
 )
 
 // draw the cells
-func draw(w int, h int, cells []Cell) *image.RGBA {
+func draw(w int, h int, sim *culture.Sim) *image.RGBA {
 	dest := image.NewRGBA(image.Rect(0, 0, w, h))
+	drawInto(dest, sim)
+	return dest
+}
+
+// draw the cells over the image, clearing it first so that the buffer of an
+// image can be reused every tick
+func drawInto(dest *image.RGBA, sim *culture.Sim) {
+	for i := range dest.Pix {
+		dest.Pix[i] = 0
+	}
 	gc := draw2dimg.NewGraphicContext(dest)
-	for _, cell := range cells {
-		gc.SetFillColor(cell.Color)
+	// the padding shrinks the cells, leaving a gap between them
+	r := float64(culture.CELLSIZE-*padding) / 2
+	for n, cell := range sim.Cells {
+		gc.SetFillColor(cellColor(sim, n))
 		gc.MoveTo(float64(cell.X), float64(cell.Y))
-		gc.ArcTo(float64(cell.X), float64(cell.Y),
-			float64(cell.R/2), float64(cell.R/2), 0, 6.283185307179586)
+		gc.ArcTo(float64(cell.X), float64(cell.Y), r, r, 0, 6.283185307179586)
 		gc.Close()
 		gc.Fill()
 	}
+	if *borders {
+		drawBorders(dest, sim)
+	}
+}
+
+// draw black lines along the edges between neighbouring cells of different
+// cultures, so that the cultural domains stand out even when their colors are
+// close. There are no borders against empty cells
+func drawBorders(dest *image.RGBA, sim *culture.Sim) {
+	gc := draw2dimg.NewGraphicContext(dest)
+	gc.SetStrokeColor(color.Black)
+	gc.SetLineWidth(1)
+	for n, cell := range sim.Cells {
+		if cell.Culture == culture.Empty {
+			continue
+		}
+		x, y, r := float64(cell.X), float64(cell.Y), float64(cell.R)/2
+		// only the neighbours to the right and below, so every edge is drawn once
+		if col := n / sim.Height; col+1 < sim.Width {
+			if c := sim.Cells[sim.CellIndex(col+1, n%sim.Height)].Culture; c != culture.Empty && c != cell.Culture {
+				gc.MoveTo(x+r, y-r)
+				gc.LineTo(x+r, y+r)
+			}
+		}
+		if row := n % sim.Height; row+1 < sim.Height {
+			if c := sim.Cells[sim.CellIndex(n/sim.Height, row+1)].Culture; c != culture.Empty && c != cell.Culture {
+				gc.MoveTo(x-r, y+r)
+				gc.LineTo(x+r, y+r)
+			}
+		}
+	}
+	gc.Stroke()
+}
+
+// scale the image down so that neither side is longer than max pixels,
+// sampling the nearest pixel of the image for every pixel of the scaled image.
+// The scaled image is drawn into dest if it is already the size, to reuse its
+// buffer every tick. An image that fits is returned as it is
+func downsample(src *image.RGBA, max int, dest *image.RGBA) *image.RGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	if max <= 0 || (w <= max && h <= max) {
+		return src
+	}
+	sw, sh := max, h*max/w
+	if h > w {
+		sw, sh = w*max/h, max
+	}
+	if sw < 1 {
+		sw = 1
+	}
+	if sh < 1 {
+		sh = 1
+	}
+	if dest == nil || dest.Rect.Dx() != sw || dest.Rect.Dy() != sh {
+		dest = image.NewRGBA(image.Rect(0, 0, sw, sh))
+	}
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			dest.SetRGBA(x, y, src.RGBAAt(src.Rect.Min.X+(2*x+1)*w/(2*sw), src.Rect.Min.Y+(2*y+1)*h/(2*sh)))
+		}
+	}
 	return dest
 }
 
+// Kelly's colors of maximum contrast, without white and black, which the
+// qualitative palette gives the first cultures it sees
+var kellyColors = []color.RGBA{
+	{0xF3, 0xC3, 0x00, 0xFF}, {0x87, 0x56, 0x92, 0xFF}, {0xF3, 0x84, 0x00, 0xFF}, {0xA1, 0xCA, 0xF1, 0xFF},
+	{0xBE, 0x00, 0x32, 0xFF}, {0xC2, 0xB2, 0x80, 0xFF}, {0x84, 0x84, 0x82, 0xFF}, {0x00, 0x88, 0x56, 0xFF},
+	{0xE6, 0x8F, 0xAC, 0xFF}, {0x00, 0x67, 0xA5, 0xFF}, {0xF9, 0x93, 0x79, 0xFF}, {0x60, 0x4E, 0x97, 0xFF},
+	{0xF6, 0xA6, 0x00, 0xFF}, {0xB3, 0x44, 0x6C, 0xFF}, {0xDC, 0xD3, 0x00, 0xFF}, {0x88, 0x2D, 0x17, 0xFF},
+	{0x8D, 0xB6, 0x00, 0xFF}, {0x65, 0x45, 0x22, 0xFF}, {0xE2, 0x58, 0x22, 0xFF}, {0x2B, 0x3D, 0x26, 0xFF},
+}
+
+// colors of the cultures with the qualitative palette, given to the cultures
+// in the order they are first drawn so that a culture keeps its color for the
+// whole simulation
+var qualitativeColors = make(map[int]color.RGBA)
+
+// the color the cell at index n is drawn with. The rgb palette draws the
+// culture of the cell as its color, and the qualitative palette gives every
+// culture its own color that stands out from the others, so that cultures
+// with close traits don't look alike
+func cellColor(sim *culture.Sim, n int) color.Color {
+	c := sim.Cells[n].Culture
+	if *paletteName != "qualitative" || c == culture.Empty {
+		return sim.Color(n)
+	}
+	clr, ok := qualitativeColors[c]
+	if !ok {
+		clr = qualitativeColor(len(qualitativeColors))
+		qualitativeColors[c] = clr
+	}
+	return clr
+}
+
+// the kth color of the qualitative palette, Kelly's colors followed by hues
+// spread around the color wheel by the golden angle, which keeps every new hue
+// far from the hues before it
+func qualitativeColor(k int) color.RGBA {
+	if k < len(kellyColors) {
+		return kellyColors[k]
+	}
+	k -= len(kellyColors)
+	h := math.Mod(float64(k)*137.50776405, 360) / 60
+	// alternate the brightness so that close hues still differ
+	s, v := 0.7, 0.95
+	if k%2 == 1 {
+		s, v = 0.9, 0.7
+	}
+	chroma := s * v
+	x := chroma * (1 - math.Abs(math.Mod(h, 2)-1))
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g = chroma, x
+	case 1:
+		r, g = x, chroma
+	case 2:
+		g, b = chroma, x
+	case 3:
+		g, b = x, chroma
+	case 4:
+		r, b = x, chroma
+	default:
+		r, b = chroma, x
+	}
+	m := v - chroma
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 0xFF}
+}
+
+// build a palette from the distinct culture colors on the grid so that every
+// culture keeps its own palette entry when the image is converted to a
+// paletted frame. The first entries are the transparent background and the
+// black of the borders and the empty cells. If there are more culture colors
+// than the rest of the palette holds, they are quantized into it
+func culturePalette(sim *culture.Sim) color.Palette {
+	black := color.RGBA{0, 0, 0, 0xFF}
+	p := color.Palette{color.Transparent, black}
+	// the distinct colors in the order of their first cell, and where they are
+	var colors []weightedColor
+	index := make(map[color.RGBA]int)
+	for n, cell := range sim.Cells {
+		if cell.Culture == culture.Empty {
+			continue
+		}
+		c := color.RGBAModel.Convert(cellColor(sim, n)).(color.RGBA)
+		if c == black {
+			continue
+		}
+		i, ok := index[c]
+		if !ok {
+			i = len(colors)
+			index[c] = i
+			colors = append(colors, weightedColor{color: c})
+		}
+		colors[i].cells++
+	}
+	if slots := 256 - len(p); len(colors) > slots {
+		return append(p, medianCut(colors, slots)...)
+	}
+	for _, c := range colors {
+		p = append(p, c.color)
+	}
+	return p
+}
+
+// a color of the grid and the number of cells drawn with it
+type weightedColor struct {
+	color color.RGBA
+	cells int
+}
+
+// quantize the colors into n colors by median cut. The box of colors with the
+// most cells is split in two at the median cell of its widest channel until
+// there are n boxes, and every box becomes the average of its colors weighted
+// by their cells
+func medianCut(colors []weightedColor, n int) []color.Color {
+	boxes := [][]weightedColor{colors}
+	for len(boxes) < n {
+		split, most := -1, 0
+		for i, box := range boxes {
+			if cells := boxCells(box); len(box) > 1 && cells > most {
+				split, most = i, cells
+			}
+		}
+		// every box is down to a single color
+		if split < 0 {
+			break
+		}
+		box := boxes[split]
+		ch := widestChannel(box)
+		sort.SliceStable(box, func(i, j int) bool { return channel(box[i].color, ch) < channel(box[j].color, ch) })
+		// the median by the cells, leaving at least one color in either half
+		at, cells := len(box)-1, 0
+		for i := range box[:len(box)-1] {
+			if cells += box[i].cells; 2*cells >= most {
+				at = i + 1
+				break
+			}
+		}
+		boxes[split] = box[:at]
+		boxes = append(boxes, box[at:])
+	}
+	p := make([]color.Color, len(boxes))
+	for i, box := range boxes {
+		var r, g, b int
+		for _, c := range box {
+			r += int(c.color.R) * c.cells
+			g += int(c.color.G) * c.cells
+			b += int(c.color.B) * c.cells
+		}
+		cells := boxCells(box)
+		p[i] = color.RGBA{uint8(r / cells), uint8(g / cells), uint8(b / cells), 0xFF}
+	}
+	return p
+}
+
+// the number of cells of the colors of a box
+func boxCells(box []weightedColor) (cells int) {
+	for _, c := range box {
+		cells += c.cells
+	}
+	return
+}
+
+// the red (0), green (1) or blue (2) channel of the color
+func channel(c color.RGBA, ch int) uint8 {
+	return [3]uint8{c.R, c.G, c.B}[ch]
+}
+
+// the channel over which the colors of a box spread the widest
+func widestChannel(box []weightedColor) (widest int) {
+	var spread uint8
+	for ch := 0; ch < 3; ch++ {
+		min, max := uint8(255), uint8(0)
+		for _, c := range box {
+			v := channel(c.color, ch)
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		if max-min > spread {
+			widest, spread = ch, max-min
+		}
+	}
+	return
+}
+
+// convert the image to a paletted image, mapping each pixel to the closest
+// color in the palette
+func paletted(img *image.RGBA, p color.Palette) *image.Paletted {
+	dest := image.NewPaletted(img.Bounds(), p)
+	indices := make(map[color.RGBA]uint8)
+	for y := dest.Rect.Min.Y; y < dest.Rect.Max.Y; y++ {
+		for x := dest.Rect.Min.X; x < dest.Rect.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			i, ok := indices[c]
+			if !ok {
+				i = uint8(p.Index(c))
+				indices[c] = i
+			}
+			dest.SetColorIndex(x, y, i)
+		}
+	}
+	return dest
+}
+
+// draw the cells as a grayscale heatmap, brighter cells have higher values
+func drawHeatmap(w int, h int, cells []culture.Cell, values []int) *image.Gray {
+	dest := image.NewGray(image.Rect(0, 0, w, h))
+	fillHeat(dest, cells, values, func(v, max int) color.Color {
+		return color.Gray{uint8(v * 255 / max)}
+	})
+	return dest
+}
+
+// draw the cells as a heatmap that glows from black through red and yellow to
+// white, hotter cells have higher values
+func drawActivityMap(w int, h int, cells []culture.Cell, values []int) *image.RGBA {
+	dest := image.NewRGBA(image.Rect(0, 0, w, h))
+	fillHeat(dest, cells, values, func(v, max int) color.Color {
+		level := float64(v) / float64(max)
+		ramp := func(from float64) uint8 {
+			return uint8(math.Max(0, math.Min(1, 3*level-from)) * 255)
+		}
+		return color.RGBA{ramp(0), ramp(1), ramp(2), 255}
+	})
+	return dest
+}
+
+// fill the square of every cell with the shade of its value out of the
+// highest value. Nothing is drawn if every value is 0
+func fillHeat(dest interface{ Set(x, y int, c color.Color) }, cells []culture.Cell, values []int, shade func(v, max int) color.Color) {
+	var max int
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	if max == 0 {
+		return
+	}
+	for i, cell := range cells {
+		intensity := shade(values[i], max)
+		for y := cell.Y - cell.R/2; y < cell.Y-cell.R/2+cell.R; y++ {
+			for x := cell.X - cell.R/2; x < cell.X-cell.R/2+cell.R; x++ {
+				dest.Set(x, y, intensity)
+			}
+		}
+	}
+}
+
+// load the population of the simulation from an image, sampling the pixel at
+// the centre of every cell of the grid scaled over the whole image. The color
+// of the pixel is the culture of the cell, and mostly transparent pixels are
+// empty cells
+func loadImage(sim *culture.Sim, path string) error {
+	imgFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer imgFile.Close()
+	src, _, err := image.Decode(imgFile)
+	if err != nil {
+		return fmt.Errorf("cannot decode image %s: %s", path, err)
+	}
+
+	bounds := src.Bounds()
+	cultures := make([]int, sim.Width*sim.Height)
+	for x := 0; x < sim.Width; x++ {
+		for y := 0; y < sim.Height; y++ {
+			px := bounds.Min.X + (2*x+1)*bounds.Dx()/(2*sim.Width)
+			py := bounds.Min.Y + (2*y+1)*bounds.Dy()/(2*sim.Height)
+			cultures[sim.CellIndex(x, y)], err = sim.ColorCulture(src.At(px, py))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	sim.PopulateGrid(cultures)
+	return nil
+}
+
 // Print the image to iTerm2 terminal
 func printImage(img image.Image) {
 	var buf bytes.Buffer
@@ -35,12 +396,80 @@ func printImage(img image.Image) {
 }
 
 // save the image
-func saveImage(filePath string, rgba *image.RGBA) {
+func saveImage(filePath string, img image.Image) {
 	imgFile, err := os.Create(filePath)
 	defer imgFile.Close()
 	if err != nil {
 		fmt.Println("Cannot create file:", err)
 	}
 
-	png.Encode(imgFile, rgba.SubImage(rgba.Rect))
+	png.Encode(imgFile, img)
+}
+
+// save the image as a binary (P6) PPM, which keeps the pixels lossless
+// for pipelines that want raw frames
+func savePPM(filePath string, rgba *image.RGBA) {
+	ppmFile, err := os.Create(filePath)
+	if err != nil {
+		fmt.Println("Cannot create file:", err)
+		return
+	}
+	defer ppmFile.Close()
+
+	bounds := rgba.Bounds()
+	w := bufio.NewWriter(ppmFile)
+	fmt.Fprintf(w, "P6\n%d %d\n255\n", bounds.Dx(), bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := rgba.RGBAAt(x, y)
+			w.Write([]byte{c.R, c.G, c.B})
+		}
+	}
+	w.Flush()
+}
+
+// save the grid as an SVG with a square for every populated cell, filled with
+// the color of its culture. Runs of cells of the same culture down a column
+// are merged into one rectangle to keep the file small, unless the cells are
+// padded apart, and empty cells are left out so that they are transparent
+func saveSVG(filePath string, sim *culture.Sim) {
+	svgFile, err := os.Create(filePath)
+	if err != nil {
+		fmt.Println("Cannot create file:", err)
+		return
+	}
+	defer svgFile.Close()
+
+	w := bufio.NewWriter(svgFile)
+	width, height := (sim.Width+1)*culture.CELLSIZE, (sim.Height+1)*culture.CELLSIZE
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		width, height, width, height)
+	for n := 0; n < len(sim.Cells); {
+		cell := sim.Cells[n]
+		// extend the run down the column while the culture stays the same
+		run := 1
+		for *padding == 0 && n+run < len(sim.Cells) && (n+run)%sim.Height != 0 && sim.Cells[n+run].Culture == cell.Culture {
+			run++
+		}
+		if cell.Culture != culture.Empty {
+			r, g, b, _ := cellColor(sim, n).RGBA()
+			fmt.Fprintf(w, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\"/>\n",
+				cell.X-cell.R/2+*padding/2, cell.Y-cell.R/2+*padding/2, cell.R-*padding, run*cell.R-*padding, r>>8, g>>8, b>>8)
+		}
+		n += run
+	}
+	fmt.Fprintln(w, "</svg>")
+	w.Flush()
+}
+
+// save the animated GIF
+func saveAnimation(filePath string, animation *gif.GIF) {
+	gifFile, err := os.Create(filePath)
+	if err != nil {
+		fmt.Println("Cannot create file:", err)
+		return
+	}
+	defer gifFile.Close()
+
+	gif.EncodeAll(gifFile, animation)
 }
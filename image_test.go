@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sausheong/culture_sim/culture"
+)
+
+// a populated simulation of a square grid with the defaults of the command line
+func testSim(t *testing.T, width int, coverage float64) *culture.Sim {
+	t.Helper()
+	params := culture.DefaultParams()
+	params.Width, params.Height, params.Coverage = width, width, coverage
+	sim, err := culture.NewSim(params, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.CreatePopulation()
+	return sim
+}
+
+// the palette of the grid as it is drawn for the animation, and the colors of
+// a pixel as it is drawn and in the frame of the animation
+func gridFrame(sim *culture.Sim) (color.Palette, func(x, y int) (color.Color, color.Color)) {
+	img := draw(sim.Width*culture.CELLSIZE+culture.CELLSIZE, sim.Height*culture.CELLSIZE+culture.CELLSIZE, sim)
+	p := culturePalette(sim)
+	frame := paletted(img, p)
+	return p, func(x, y int) (color.Color, color.Color) { return img.At(x, y), frame.At(x, y) }
+}
+
+func TestCulturePaletteKeepsCultures(t *testing.T) {
+	useTestFlags(t)
+	*borders = true
+	sim := testSim(t, 12, 0.9)
+	p, pixel := gridFrame(sim)
+	if p[0] != color.Transparent || p[1] != (color.RGBA{0, 0, 0, 0xFF}) {
+		t.Fatalf("the palette starts with %v and %v, want transparent and black", p[0], p[1])
+	}
+	for n, cell := range sim.Cells {
+		drawn, framed := pixel(cell.X, cell.Y)
+		if !sameColor(drawn, framed) {
+			t.Errorf("cell %d of culture %06X is drawn %v but is %v in the frame", n, cell.Culture, drawn, framed)
+		}
+	}
+	// the border lines keep their black
+	bounds := 13 * culture.CELLSIZE
+	for x := 0; x < bounds; x++ {
+		for y := 0; y < bounds; y++ {
+			if drawn, framed := pixel(x, y); sameColor(drawn, color.Black) && !sameColor(framed, color.Black) {
+				t.Fatalf("the black of the borders at %d,%d is %v in the frame", x, y, framed)
+			}
+		}
+	}
+}
+
+func TestCulturePaletteQuantizes(t *testing.T) {
+	useTestFlags(t)
+	// the default grid has more cultures than a palette holds
+	sim := testSim(t, 36, 1)
+	if sim.DistinctCultures() <= 254 {
+		t.Fatalf("only %d cultures on the grid", sim.DistinctCultures())
+	}
+	p, pixel := gridFrame(sim)
+	if len(p) != 256 || p[0] != color.Transparent || p[1] != (color.RGBA{0, 0, 0, 0xFF}) {
+		t.Fatalf("the palette has %d colors starting with %v and %v, want 256 starting with transparent and black", len(p), p[0], p[1])
+	}
+	// every culture is drawn with a close color of its own, not the background
+	// or the black of the empty cells
+	var errs float64
+	for n, cell := range sim.Cells {
+		drawn, framed := pixel(cell.X, cell.Y)
+		if sameColor(framed, color.Transparent) || sameColor(framed, color.Black) && !sameColor(drawn, color.Black) {
+			t.Fatalf("cell %d of culture %06X is drawn %v but is %v in the frame", n, cell.Culture, drawn, framed)
+		}
+		errs += colorDistance(drawn, framed)
+	}
+	if mean := errs / float64(len(sim.Cells)); mean > 40 {
+		t.Errorf("the cultures are on average %.1f away from their colors in the frame", mean)
+	}
+}
+
+// whether the colors are the same once they are turned into RGBA
+func sameColor(a, b color.Color) bool {
+	return color.RGBAModel.Convert(a) == color.RGBAModel.Convert(b)
+}
+
+// the euclidean distance of the colors in 8 bit RGB
+func colorDistance(a, b color.Color) float64 {
+	ar, ag, ab, _ := a.RGBA()
+	br, bg, bb, _ := b.RGBA()
+	dr, dg, db := float64(ar>>8)-float64(br>>8), float64(ag>>8)-float64(bg>>8), float64(ab>>8)-float64(bb>>8)
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
+
+// the width, height and pixels of a binary PPM
+func readPPM(t *testing.T, path string) (w, h int, pixels []byte) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var maxval int
+	if _, err := fmt.Fscanf(r, "P6\n%d %d\n%d\n", &w, &h, &maxval); err != nil {
+		t.Fatalf("the PPM header is wrong: %v", err)
+	}
+	if maxval != 255 {
+		t.Errorf("the PPM has a maximum value of %d, want 255", maxval)
+	}
+	if pixels, err = io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestSavePPM(t *testing.T) {
+	useTestFlags(t)
+	sim := testSim(t, 8, 0.8)
+	img := draw(9*culture.CELLSIZE, 9*culture.CELLSIZE, sim)
+	path := filepath.Join(t.TempDir(), "grid.ppm")
+	savePPM(path, img)
+	w, h, pixels := readPPM(t, path)
+	if w != 90 || h != 90 {
+		t.Fatalf("the PPM is %dx%d, want 90x90", w, h)
+	}
+	if len(pixels) != 3*w*h {
+		t.Fatalf("the PPM has %d bytes of pixels, want %d", len(pixels), 3*w*h)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c, i := img.RGBAAt(x, y), 3*(y*w+x)
+			if pixels[i] != c.R || pixels[i+1] != c.G || pixels[i+2] != c.B {
+				t.Fatalf("pixel %d,%d of the PPM is %v, want %v", x, y, pixels[i:i+3], c)
+			}
+		}
+	}
+}
+
+func TestPPMFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "last.ppm")
+	runProgram(t, "-seed", "1", "-w", "6", "-t", "2", "-ppm", path)
+	// a grid of 6 cells of 10 pixels with a margin of half a cell around it
+	w, h, pixels := readPPM(t, path)
+	if w != 70 || h != 70 || len(pixels) != 3*70*70 {
+		t.Errorf("the PPM is %dx%d with %d bytes of pixels, want 70x70 with %d", w, h, len(pixels), 3*70*70)
+	}
+}
@@ -1,186 +1,1438 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"flag"
 	"fmt"
 	"image"
+	"image/gif"
 	"log"
-	"math/rand"
+	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/nsf/termbox-go"
+	"github.com/sausheong/culture_sim/culture"
 )
 
 // image shown on the screen
 var img *image.RGBA
 
-// the simulation grid
-var cells []Cell
-
-// the number of cells on one side of the image
+// the number of cells on one side of the image, its width
 var width *int
 
+// the number of cells on the other side of the image, its height (0 for a square image)
+var height *int
+
 // number of interactions between cultures per simulation tick
 var interactions *int
 
+// whether the interactions are per tick or per populated cell
+var nMode *string
+
 // percentage of simulation grid that is populated with cultures
 var coverage *float64
 
+// populate exactly the coverage of the grid instead of each cell with the
+// probability of the coverage
+var exactCoverage *bool
+
+// initial cultures of the population, random, stripes or clusters
+var initPattern *string
+
+// number of cultures of the stripes and clusters initializations
+var initCultures *int
+
 // number of simulation ticks
 var numTicks *int
 
-// simulation data
-var fdistances []string // average distance between features
-var changes []string    // number of cultural changes
-var uniques []string    // number of unique cultures
+// JSON file with the simulation parameters
+var configPath *string
 
-func main() {
-	rand.Seed(time.Now().UTC().UnixNano())
+// save a checkpoint of the simulation every this many ticks (0 to never save one)
+var checkpointEvery *int
+
+// checkpoint file to restore the simulation from
+var restorePath *string
+
+// CSV file to record every interaction to
+var recordPath *string
+
+// CSV file of recorded interactions to replay instead of running the interactions
+var replayPath *string
+
+// seed of the random number generator, 0 to seed from the clock
+var seed *int64
+
+// number of goroutines the interactions of a tick are split across
+var workers *int
+
+// number of cultural features
+var features *int
+
+// number of possible traits of a cultural feature
+var traits *int
+
+// neighbourhood of a cell, either vonneumann (4 orthogonal cells) or moore (8 surrounding cells)
+var neighbourhood *string
+
+// update mode of the grid, async (interactions see earlier changes) or sync
+// (interactions see the grid at the start of the tick)
+var update *string
+
+// radius of the neighbourhood of a cell
+var radius *int
+
+// wrap the edges of the grid around so that it becomes a torus
+var wrap *bool
+
+// wrap the left and right edges of the grid around
+var wrapX *bool
+
+// wrap the top and bottom edges of the grid around
+var wrapY *bool
+
+// probability of a populated cell randomly changing one of its traits every tick
+var drift *float64
+
+// comma-separated probabilities of every feature of a populated cell randomly changing its trait every tick
+var driftPerFeature *string
+
+// probability of a populated cell swapping its culture with another populated cell every tick
+var migration *float64
+
+// probability of a populated cell dying and being reborn every tick
+var turnover *float64
+
+// culture of a reborn cell, random or inherit
+var turnoverMode *string
+
+// culture broadcast by the mass media, as a hex culture integer or its traits separated by colons
+var media *string
+
+// probability that an interaction is with the mass media instead of the neighbours
+var mediaStrength *float64
+
+// cost incurred by a cell every time it initiates an interaction
+var interactionCost *float64
+
+// cumulative interaction cost after which a cell stops initiating interactions (0 for no limit)
+var budget *float64
+
+// randomize the order in which the neighbours of a cell are interacted with
+var shuffleNeighbours *bool
+
+// draw the borders between cultural domains on the images of the grid
+var borders *bool
+
+// colors the cultures are drawn with, rgb or qualitative
+var paletteName *string
+
+// size of every cell on the images of the grid, in pixels
+var cellSize *int
+
+// gap between the cells on the images of the grid, in pixels
+var padding *int
+
+// longest side of the image of the grid shown on screen or in a browser, in pixels
+var maxPixels *int
+
+// address to serve the live grid on to browsers, such as :8080
+var serveAddr *string
+
+// push a frame to the browsers every this many ticks
+var serveEvery *int
+
+// run without the terminal display, for batch runs and machines without a TTY
+var headless *bool
+
+// suppress the metrics printed every tick, keeping the grid on the terminal display
+var quiet *bool
+
+// report the throughput of the simulation instead of the metrics of every tick
+var bench *bool
+
+// number of simulations run with the same parameters, whose metrics are averaged
+var runs *int
+
+// parameter swept over a range as name:start:end:step, running the ensemble at each value
+var sweep *string
+
+// directory the data of the simulation is saved to
+var outputDir *string
+
+// grid CSV file to load the initial population from
+var loadPath *string
+
+// PNG image to load the initial population from
+var imageInit *string
 
-	// capture the simulation parameters
-	interactions = flag.Int("n", 100, "number of interactions between cultures per simulation tick")
+// stream the metrics of every tick as JSON lines
+var saveJSONL *bool
+
+// layout of the data log, wide or long
+var csvFormat *string
+
+// save an image of the grid for every frame
+var saveFrames *bool
+
+// save a frame every this many ticks
+var frameEvery *int
+
+// save an animated GIF of the simulation
+var saveGif *bool
+
+// delay between the frames of the GIF, in 100ths of a second
+var gifDelay *int
+
+// add a frame to the GIF every this many ticks
+var gifEvery *int
+
+// frames of the animated GIF of the simulation
+var animation gif.GIF
+
+// save a heatmap of the number of times each cell changed culture
+var changeHeatmap *bool
+
+// save an image of how active every cell was, by the number of times it changed culture
+var activityMap *bool
+
+// save the size distribution of the frozen and still active domains
+var traps *bool
+
+// number of ticks at the start of the simulation that are not logged
+var burnin *int
+
+// end the simulation once no more cultural exchange is possible
+var stopOnConvergence *bool
+
+// delay between simulation ticks, in milliseconds
+var delayMs *int
+
+// maximum number of simulation ticks per second, overriding the delay (0 for no limit)
+var fps *int
+
+// tick at which the simulation pauses until the spacebar is pressed (0 to never pause)
+var pauseAt *int
+
+// distance between traits, manhattan or hamming
+var distance *string
+
+// rule of the probability of an exchange, homophily or xenophily
+var rule *string
+
+// shape of the probability of an exchange, linear, exponential or step
+var probFunc *string
+
+// rate of the exponential probability of an exchange
+var probK *float64
+
+// number of features cultures must share to exchange with the step probability
+var probShared *int
+
+// thermal noise that lets dissimilar cultures exchange (0 for none)
+var temperature *float64
+
+// probability that a populated cell colonizes an empty neighbour it interacts with
+var colonize *float64
+
+// tick at which a culture is injected into the grid (0 to never inject)
+var injectAt *int
+
+// culture injected into the grid, as a hex culture integer or its traits separated by colons
+var injectCulture *string
+
+// radius of the patch of cells around the injected cell that also get the culture
+var injectRadius *int
+
+// grid CSV file compared to the grid CSV file given as the argument, instead of running a simulation
+var diffPath *string
+
+// PNG image file to save the cells that differ between the compared grids to
+var diffImage *string
+
+// distribution of the probability of the cells resisting a change to their culture
+var stubbornness *string
+
+// model of the cultural dynamics, axelrod or deffuant
+var model *string
+
+// distance between opinions below which cultures interact in the deffuant model
+var confidence *float64
+
+// fraction of the way to their average the opinions of interacting cultures move in the deffuant model
+var convergenceRate *float64
+
+// stop the simulation on anomalies instead of logging them
+var strict *bool
+
+// file path to also save the last image of the grid as a PPM
+var ppmPath *string
+
+// save the last grid as an SVG
+var saveSVGImage *bool
+
+// comma-separated names of the metrics computed and logged every tick
+var metricNames *string
+
+func main() {
+	// capture the simulation parameters, with the defaults of the simulation
+	defaults := culture.DefaultParams()
+	interactions = flag.Int("n", defaults.Interactions, "number of interactions between cultures per simulation tick, or per populated cell with -n-mode per-populated")
+	nMode = flag.String("n-mode", defaults.NMode, "how the interactions of a tick are counted, absolute (n interactions of randomly picked cells, some of them empty) or per-populated (n interactions for every populated cell, picking only populated cells, to compare grids of different coverages)")
 	numTicks = flag.Int("t", 200, "number of simulation ticks")
-	width = flag.Int("w", 36, "the number of cells on one side of the image")
-	coverage = flag.Float64("c", 1.0, "percentage of simulation grid that is populated with cultures")
+	width = flag.Int("w", defaults.Width, "the number of cells on one side of the image")
+	height = flag.Int("height", defaults.Height, "the number of cells on the other side of the image, the same as -w if 0")
+	coverage = flag.Float64("c", defaults.Coverage, "percentage of simulation grid that is populated with cultures")
+	exactCoverage = flag.Bool("exact-coverage", defaults.ExactCoverage, "populate exactly the coverage of the grid, rounded to the nearest cell, instead of each cell with the probability of the coverage")
+	initPattern = flag.String("init", defaults.Init, "initial cultures, random, stripes (a culture for every band of rows) or clusters (the culture of the nearest seed cell)")
+	initCultures = flag.Int("init-k", defaults.InitCultures, "number of cultures, bands or seed cells, of the stripes and clusters initializations")
+	features = flag.Int("features", defaults.Features, "number of cultural features")
+	traits = flag.Int("traits", defaults.Traits, "number of possible traits of a cultural feature")
+	neighbourhood = flag.String("neighbourhood", defaults.Neighbourhood, "neighbourhood of a cell, vonneumann (4 orthogonal cells) or moore (8 surrounding cells)")
+	update = flag.String("update", defaults.Update, "update mode, async (interactions see earlier changes) or sync (interactions see the grid at the start of the tick)")
+	radius = flag.Int("radius", defaults.Radius, "radius of the neighbourhood of a cell")
+	seed = flag.Int64("seed", 0, "seed of the random number generator, 0 to seed from the clock")
+	workers = flag.Int("workers", defaults.Workers, "number of goroutines the interactions of a tick are split across; more than 1 is not reproducible with a seed")
+	wrap = flag.Bool("wrap", defaults.Wrap, "wrap the edges of the grid around so that it becomes a torus")
+	wrapX = flag.Bool("wrap-x", defaults.WrapX, "wrap the left and right edges of the grid around so that it becomes a cylinder, which with -wrap-y is the same as -wrap")
+	wrapY = flag.Bool("wrap-y", defaults.WrapY, "wrap the top and bottom edges of the grid around so that it becomes a cylinder, which with -wrap-x is the same as -wrap")
+	metricNames = flag.String("metrics", "distance,change,unique,domains,largest,entropy,boundaries,active", "comma-separated list of metrics to compute and log, feature-entropy for the entropy of every feature")
+	distance = flag.String("distance", defaults.Distance, "distance between the traits of a feature, manhattan (traits are ordered, the difference between them) or hamming (traits are categories, 0 if the same and 1 if different)")
+	rule = flag.String("rule", defaults.Rule, "probability of an exchange, homophily (1 - d/maxDiff, similar cultures are more likely to exchange) or xenophily (d/maxDiff, different cultures are)")
+	probFunc = flag.String("prob-func", defaults.ProbFunc, "shape of the similarity of 2 cultures a trait distance d apart that the rule turns into the probability of an exchange, linear (1 - d/maxDiff), exponential (exp(-k*d) with the prob-k rate) or step (1 if the cultures share at least prob-shared features, 0 if not)")
+	probK = flag.Float64("prob-k", defaults.ProbK, "rate k at which the exponential probability function exp(-k*d) falls with the trait distance d")
+	probShared = flag.Int("prob-shared", defaults.ProbShared, "number of features 2 cultures must share for the step probability function to let them exchange")
+	temperature = flag.Float64("temperature", defaults.Temperature, "thermal noise with which exchanges rejected by the similarity are still accepted with a Glauber probability, 0 for none")
+	colonize = flag.Float64("colonize", defaults.Colonize, "probability that a populated cell copies its culture into an empty neighbour it interacts with, logged by the colonies metric")
+	injectAt = flag.Int("inject-at", 0, "tick at which the inject-culture is injected into a random populated cell, 0 to never inject")
+	injectCulture = flag.String("inject-culture", "", "culture injected into the grid, as a hex culture integer such as 0x1A2B3C or as the trait of every feature separated by colons such as 12:3:11:2:10:1")
+	injectRadius = flag.Int("inject-radius", 0, "radius of the patch of populated cells around the injected cell that also get the culture")
+	diffPath = flag.String("diff", "", "compare this grid CSV with the grid CSV given as the argument, -diff <grid A> <grid B>, instead of running a simulation")
+	diffImage = flag.String("diff-image", "", "save an image of the cells that differ between the compared grids to this PNG path")
+	stubbornness = flag.String("stubbornness", defaults.Stubbornness, "distribution of the probability of a cell resisting a change to its culture, a value for every cell, uniform:min:max or fraction:p:v for a fraction p of cells with a stubbornness of v; none if empty")
+	model = flag.String("model", defaults.Model, "model of the cultural dynamics, axelrod (cultures copy each other's discrete traits) or deffuant (every feature is an opinion between 0 and 1, and cultures within the confidence move their opinions towards their average)")
+	confidence = flag.Float64("confidence", defaults.Confidence, "average distance between the opinions of 2 cultures below which they interact in the deffuant model")
+	convergenceRate = flag.Float64("convergence-rate", defaults.ConvergenceRate, "fraction of the way to their average the opinions of interacting cultures move in the deffuant model")
+	strict = flag.Bool("strict", defaults.Strict, "stop the simulation with an error on anomalies such as out of range distances")
+	saveSVGImage = flag.Bool("svg", false, "also save the last grid as an SVG to <name>.svg in the output directory, for figures that scale")
+	ppmPath = flag.String("ppm", "", "also save the last image of the grid as a binary PPM to this path")
+	migration = flag.Float64("migration", defaults.Migration, "probability of a populated cell swapping its culture with another randomly picked populated cell every tick, logged by the migrations metric")
+	turnover = flag.Float64("turnover", defaults.Turnover, "probability of a populated cell dying and being reborn in its place with a new culture every tick, which keeps the coverage, logged by the turnover metric")
+	turnoverMode = flag.String("turnover-mode", defaults.TurnoverMode, "culture of a reborn cell, random (a fresh random culture) or inherit (the culture of a randomly picked populated neighbour)")
+	drift = flag.Float64("drift", defaults.Drift, "probability of a populated cell randomly changing one of its traits every tick, logged by the drift metric")
+	driftPerFeature = flag.String("drift-per-feature", defaults.DriftPerFeature, "comma-separated probabilities of every feature of a populated cell randomly changing its trait every tick, one for every feature such as 0.01,0,0,0,0.05,0, instead of -drift")
+	media = flag.String("media", "", "culture broadcast by the mass media, as a hex culture integer such as 0x1A2B3C or as the trait of every feature separated by colons such as 12:3:11:2:10:1")
+	mediaStrength = flag.Float64("media-strength", defaults.MediaStrength, "probability that an interaction is with the mass media instead of the neighbours")
+	interactionCost = flag.Float64("interactioncost", defaults.InteractionCost, "cost incurred by a cell every time it initiates an interaction")
+	budget = flag.Float64("budget", defaults.Budget, "cumulative interaction cost after which a cell stops initiating interactions (0 for no limit)")
+	shuffleNeighbours = flag.Bool("shuffle-neighbours", defaults.ShuffleNeighbours, "randomize the order in which a cell interacts with its neighbours")
+	cellSize = flag.Int("cellsize", 10, "size of every cell on the images of the grid in pixels, small for quick previews and large for print")
+	padding = flag.Int("padding", 0, "gap in pixels between the cells on the images of the grid, less than the cellsize")
+	paletteName = flag.String("palette", "rgb", "colors the cultures are drawn with on the images of the grid, rgb (the culture as its color, so cultures with close traits have close colors) or qualitative (a distinct color for every culture, so the domains stand out); the model doesn't change")
+	borders = flag.Bool("borders", false, "draw black lines on the images of the grid between neighbouring cells of different cultures")
+	maxPixels = flag.Int("max-pixels", 0, "scale the grid shown on the terminal or in a browser down so that neither side is longer than this many pixels, while the saved images stay full size (0 for no limit)")
+	serveAddr = flag.String("serve", "", "serve the live grid on this address, such as :8080, to watch in a browser instead of the terminal; implies -headless")
+	serveEvery = flag.Int("serve-every", 1, "push a frame of the grid to the browsers every this many ticks")
+	headless = flag.Bool("headless", false, "run without the terminal display")
+	quiet = flag.Bool("quiet", false, "don't print the metrics of every tick; the grid is still displayed unless running headless and the data is still saved at the end")
+	bench = flag.Bool("bench", false, "report the ticks and interactions per second and the memory used at the end instead of the metrics of every tick; implies -headless")
+	runs = flag.Int("runs", 1, "number of simulations run headless with the same parameters and consecutive seeds, saving the mean and standard deviation of every metric")
+	sweep = flag.String("sweep", "", "sweep a parameter over a range as name:start:end:step, such as coverage:0.1:1.0:0.1, running -runs simulations at each value and saving the final metrics of every value")
+	outputDir = flag.String("output-dir", "data", "directory the data of the simulation is saved to, created if it doesn't exist")
+	loadPath = flag.String("load", "", "load the initial population from a grid CSV saved by a previous simulation")
+	imageInit = flag.String("image-init", "", "load the initial population from a PNG image scaled to the grid, with the color of a cell as its culture and transparent pixels as empty cells; needs 6 features of 16 traits")
+	csvFormat = flag.String("csv-format", "wide", "layout of the data log, wide (a row of the values of every tick for every metric) or long (tick,metric,value columns with a line for every tick and metric, for plotting tools that want tidy data)")
+	saveJSONL = flag.Bool("jsonl", false, "stream the metrics of every tick as JSON lines to <name>.jsonl in the output directory")
+	saveFrames = flag.Bool("frames", false, "save an image of the grid every tick to frames/<name>/ in the output directory")
+	frameEvery = flag.Int("frame-every", 1, "save a frame every this many ticks")
+	saveGif = flag.Bool("gif", false, "save an animated GIF of the simulation")
+	gifDelay = flag.Int("gif-delay", 10, "delay between the frames of the GIF, in 100ths of a second")
+	gifEvery = flag.Int("gif-every", 1, "add a frame to the GIF every this many ticks")
+	activityMap = flag.Bool("activity-map", false, "save an image of the grid to activity-<name>.png in the output directory that glows brighter for the cells that changed culture more often")
+	traps = flag.Bool("traps", false, "save the number of frozen domains, whose cells share no feature with any neighbour of another culture, and of still active domains of every size at the end of the simulation to traps-<name>.csv in the output directory")
+	changeHeatmap = flag.Bool("change-heatmap", false, "save a CSV and grayscale image of the number of times each cell changed culture")
+	burnin = flag.Int("burnin", 0, "number of burn-in ticks at the start of the simulation excluded from the data log")
+	stopOnConvergence = flag.Bool("stop-on-convergence", false, "end the simulation once no more cultural exchange is possible")
+	fps = flag.Int("fps", 0, "run at most this many simulation ticks per second at a steady rate for recording, instead of the delay (0 for no limit)")
+	delayMs = flag.Int("delay", 0, "delay between simulation ticks in milliseconds, change it with + and - while running")
+	pauseAt = flag.Int("pause-at", 0, "pause the simulation when this tick is reached, resume with the spacebar")
+	checkpointEvery = flag.Int("checkpoint-every", 0, "save a checkpoint to <name>.checkpoint.json in the output directory every this many ticks, to continue the simulation from with -restore (0 to never save one)")
+	restorePath = flag.String("restore", "", "continue the simulation from a checkpoint with its parameters, overridden by the flags on the command line, as if it had never stopped; the GIF only has the frames from then on")
+	recordPath = flag.String("record", "", "record every interaction to this CSV file, with its tick, cell, neighbour, feature, direction and whether it fired, to replay with -replay")
+	replayPath = flag.String("replay", "", "replay the interactions recorded to this CSV file with -record instead of running the interactions, with the same parameters and seed as the recording, to reproduce its grid exactly")
+	configPath = flag.String("config", "", "JSON file with the simulation parameters, overridden by the flags on the command line")
 	flag.Parse()
 
-	// using termbox to control the simulation
-	termbox.Init()
+	if *configPath != "" {
+		if err := applyConfig(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// a restored simulation runs with the parameters of its checkpoint
+	var restored *checkpoint
+	if *restorePath != "" {
+		cp, err := loadCheckpoint(*restorePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := applyParams(cp.Config, *restorePath); err != nil {
+			log.Fatal(err)
+		}
+		if err := checkRestoredSeed(cp, *restorePath); err != nil {
+			log.Fatal(err)
+		}
+		restored = &cp
+	}
+	if *bench || *serveAddr != "" {
+		*headless = true
+	}
+
+	if *paletteName != "rgb" && *paletteName != "qualitative" {
+		log.Fatalf("palette must be rgb or qualitative, got %q", *paletteName)
+	}
+	if *cellSize < 2 {
+		log.Fatalf("cellsize must be at least 2, got %d", *cellSize)
+	}
+	if *padding < 0 || *padding >= *cellSize {
+		log.Fatalf("padding must be between 0 and less than the cellsize of %d, got %d", *cellSize, *padding)
+	}
+	culture.CELLSIZE = *cellSize
+
+	// a fixed seed makes the simulation reproducible
+	if *seed == 0 {
+		*seed = time.Now().UTC().UnixNano()
+	}
+
+	params, err := simParams()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sim, err := culture.NewSim(params, *seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *numTicks < 0 {
+		log.Fatalf("ticks cannot be negative, got %d", *numTicks)
+	}
+	if *frameEvery < 1 {
+		log.Fatalf("frame-every must be at least 1, got %d", *frameEvery)
+	}
+	if *delayMs < 0 {
+		log.Fatalf("delay cannot be negative, got %d", *delayMs)
+	}
+	if *fps < 0 || *fps > 1000 {
+		log.Fatalf("fps must be between 0 and 1000, got %d", *fps)
+	}
+	if *gifEvery < 1 {
+		log.Fatalf("gif-every must be at least 1, got %d", *gifEvery)
+	}
+	if *gifDelay < 0 {
+		log.Fatalf("gif-delay cannot be negative, got %d", *gifDelay)
+	}
+	// the injected culture is kept as its traits, as the culture integer of
+	// a wide culture only holds within its simulation
+	var injectTraits []int
+	if *injectAt > 0 {
+		var err error
+		if injectTraits, err = culture.ParseTraits(*injectCulture, *features, *traits); err == nil {
+			_, err = sim.Culture(injectTraits)
+		}
+		if err != nil {
+			log.Fatalf("invalid inject culture %q for %d features of %d traits: %s", *injectCulture, *features, *traits, err)
+		}
+	}
+	if *loadPath != "" && *imageInit != "" {
+		log.Fatal("only one of load and image-init can be used")
+	}
+	if *runs < 1 {
+		log.Fatalf("runs must be at least 1, got %d", *runs)
+	}
+	if *maxPixels < 0 {
+		log.Fatalf("max-pixels cannot be negative, got %d", *maxPixels)
+	}
+	if *serveEvery < 1 {
+		log.Fatalf("serve-every must be at least 1, got %d", *serveEvery)
+	}
+	if *checkpointEvery < 0 {
+		log.Fatalf("checkpoint-every cannot be negative, got %d", *checkpointEvery)
+	}
+	if restored != nil && (*runs > 1 || *sweep != "") {
+		log.Fatal("a single simulation can be restored, not an ensemble or a sweep")
+	}
+	if *injectRadius < 0 {
+		log.Fatalf("inject-radius cannot be negative, got %d", *injectRadius)
+	}
+	if *csvFormat != "wide" && *csvFormat != "long" {
+		log.Fatalf("csv-format must be wide or long, got %q", *csvFormat)
+	}
+	if *recordPath != "" && *replayPath != "" {
+		log.Fatal("only one of record and replay can be used")
+	}
+	if *recordPath != "" || *replayPath != "" {
+		if err := unrecordable(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := sim.SelectMetrics(*metricNames); err != nil {
+		log.Fatal(err)
+	}
+
+	// comparing grids doesn't run a simulation
+	if *diffPath != "" {
+		if flag.NArg() != 1 {
+			log.Fatal("diff needs 2 grids after all the other flags, -diff <grid A> <grid B>")
+		}
+		if err := diffGrids(sim, *diffPath, flag.Arg(0), *diffImage); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	simName := simulationName(sim)
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		log.Fatalf("failed creating directory: %s", err)
+	}
+	// every simulation documents the parameters it ran with
+	if err := saveConfig(filepath.Join(*outputDir, simName+".config.json")); err != nil {
+		log.Fatalf("failed saving config: %s", err)
+	}
+
+	// interrupting or terminating the process cancels the simulation, which
+	// still saves the data it has so far
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// a sweep runs the ensemble for every value of the parameter
+	if *sweep != "" {
+		if err := runSweep(ctx, *sweep, simName, *runs, injectTraits); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// an ensemble runs many simulations without a display
+	if *runs > 1 {
+		if err := runEnsemble(ctx, params, simName, *runs, injectTraits); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	framesDir := filepath.Join(*outputDir, "frames", simName)
+	if *saveFrames {
+		if err := os.MkdirAll(framesDir, 0755); err != nil {
+			log.Fatalf("failed creating directory: %s", err)
+		}
+	}
+
+	// the JSON lines are written as the simulation runs so that they survive
+	// the simulation ending early
+	var jsonFile *os.File
+	if *saveJSONL {
+		// a restored simulation carries on from the lines written up to its checkpoint
+		jsonPath := filepath.Join(*outputDir, simName+".jsonl")
+		if restored != nil {
+			jsonFile, err = reopenJSONL(jsonPath, restored.Tick)
+		} else {
+			jsonFile, err = os.Create(jsonPath)
+		}
+		if err != nil {
+			log.Fatalf("failed creating file: %s", err)
+		}
+	}
+
+	if restored != nil {
+		if err := sim.Restore(restored.State); err != nil {
+			log.Fatalf("cannot restore %s: %s", *restorePath, err)
+		}
+	} else if err := populate(sim); err != nil {
+		log.Fatal(err)
+	}
+	injected := injectedCulture(sim, injectTraits)
+	// the interactions are recorded as they happen, or replayed from a
+	// recording of a simulation populated in the same way
+	var recording *recorder
+	var replay *replayer
+	if *recordPath != "" {
+		if recording, err = startRecording(sim, *recordPath); err != nil {
+			log.Fatalf("failed creating file: %s", err)
+		}
+	}
+	if *replayPath != "" {
+		if replay, err = openReplay(*replayPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// the image is drawn over every tick, and the initial grid is the last
+	// image if the simulation has no ticks
+	img = draw(sim.Width*culture.CELLSIZE+culture.CELLSIZE, sim.Height*culture.CELLSIZE+culture.CELLSIZE, sim)
+	var preview *image.RGBA
+
+	// browsers watch the simulation in place of the terminal
+	var server *frameServer
+	if *serveAddr != "" {
+		if server, err = startServer(*serveAddr); err != nil {
+			log.Fatalf("cannot serve on %s: %s", *serveAddr, err)
+		}
+	}
+
+	// using termbox to control the simulation, unless running headless in
+	// which case there are no keyboard events
 	endSim := false
+	var events chan termbox.Event
+	if !*headless {
+		termbox.Init()
 
-	// poll for keyboard events in another goroutine
-	events := make(chan termbox.Event, 1000)
-	go func() {
-		for {
-			events <- termbox.PollEvent()
+		// poll for keyboard events in another goroutine
+		events = make(chan termbox.Event, 1000)
+		go func() {
+			for {
+				events <- termbox.PollEvent()
+			}
+		}()
+	}
+
+	// closing termbox restores the terminal, and must only happen once
+	terminalClosed := *headless
+	closeTerminal := func() {
+		if !terminalClosed {
+			termbox.Close()
+			terminalClosed = true
 		}
-	}()
+	}
 
-	// create the initial population
-	createPopulation()
+	converged := -1
+	// tick at which the injected culture took over every populated cell
+	fixation := -1
+	// tick at which every populated cell first had the same culture
+	monoculture := -1
+
+	// tick of the grid on screen, -1 before the first tick
+	shown := -1
+	keys := &controls{
+		delay:  time.Duration(*delayMs) * time.Millisecond,
+		cancel: cancel,
+		snapshot: func() string {
+			stem := saveSnapshot(sim, simName, shown)
+			return fmt.Sprintf("Snapshot of tick %d saved to %s", shown, filepath.Join(*outputDir, stem+".*"))
+		},
+		// the restarted simulation wouldn't match the recording
+		fixed: recording != nil || replay != nil,
+	}
+	var totalExchanges, totalMedia int
+	// a restored simulation continues from the tick after its checkpoint
+	first := 0
+	if restored != nil {
+		first = restored.Tick + 1
+		shown = restored.Tick
+		totalExchanges, totalMedia, fixation = restored.Exchanges, restored.Media, restored.Fixation
+		monoculture = restored.Monoculture
+	}
+
+	// the recent values of the metrics drawn as sparklines on the terminal
+	// display, nil for the metrics without one
+	trends := make([][]float64, len(sim.Metrics))
+	sparklines := false
+	for i, m := range sim.Metrics {
+		if !*headless && (m.Name() == "distance" || m.Name() == "unique" || m.Name() == "change") {
+			trends[i] = []float64{}
+			sparklines = true
+		}
+	}
+
+	var ticker *time.Ticker
+	if *fps > 0 {
+		ticker = time.NewTicker(time.Second / time.Duration(*fps))
+		defer ticker.Stop()
+	}
 
 	// main simulation loop
-	for t := 0; !endSim && (t < *numTicks); t++ {
-		// data captured for every loop of simulation
-		var dist, chg, uniq int
+	start := time.Now()
+	ticks, attempts := 0, 0
+	for t := first; !endSim && ctx.Err() == nil && (t < *numTicks); t++ {
+		ticks++
 
-		// capture the ctrl-q key to end the simulation
+		// capture the keys controlling the simulation
 		select {
 		case ev := <-events:
-			if ev.Type == termbox.EventKey {
-				if ev.Key == termbox.KeyCtrlQ {
-					endSim = true
+			keys.handleEvent(ev)
+		default:
+		}
+
+		// a restart runs a fresh simulation with the next seed from its first
+		// tick, the same simulation as running with that seed from the start.
+		// The data saved at the end are those of the last simulation, named
+		// after and with the seed to rerun it, next to its config
+		if keys.restart {
+			keys.restart = false
+			*seed++
+			if sim, err = culture.NewSim(params, *seed); err == nil {
+				if err = sim.SelectMetrics(*metricNames); err == nil {
+					err = populate(sim)
 				}
 			}
-		default:
+			if err != nil {
+				closeTerminal()
+				log.Fatal(err)
+			}
+			simName = simulationName(sim)
+			if err := saveConfig(filepath.Join(*outputDir, simName+".config.json")); err != nil {
+				closeTerminal()
+				log.Fatalf("failed saving config: %s", err)
+			}
+			framesDir = filepath.Join(*outputDir, "frames", simName)
+			if *saveFrames {
+				if err := os.MkdirAll(framesDir, 0755); err != nil {
+					closeTerminal()
+					log.Fatalf("failed creating directory: %s", err)
+				}
+			}
+			injected = injectedCulture(sim, injectTraits)
+			t, shown = 0, -1
+			totalExchanges, totalMedia = 0, 0
+			converged, fixation, monoculture = -1, -1, -1
+			for i := range trends {
+				if trends[i] != nil {
+					trends[i] = trends[i][:0]
+				}
+			}
+			animation.Image, animation.Delay = nil, nil
+			if jsonFile != nil {
+				jsonFile.Close()
+				if jsonFile, err = os.Create(filepath.Join(*outputDir, simName+".jsonl")); err != nil {
+					closeTerminal()
+					log.Fatalf("failed creating file: %s", err)
+				}
+			}
+			keys.notice = fmt.Sprintf("Simulation restarted with seed %d", *seed)
+			keys.noticeUntil = time.Now().Add(3 * time.Second)
+		}
+
+		// introduce a new culture into the grid before this tick's interactions
+		if *injectAt > 0 && t == *injectAt {
+			count := sim.Inject(injected, *injectRadius)
+			fmt.Printf("Injected culture %s into %d cells at tick %d\n", *injectCulture, count, t)
 		}
 
 		// every simulation loop randomly pick a number of cells and
-		// get them to have cultural exchange with their neighbours depending
-		// the calculated probability. The more similar the cultures are, the
-		// more likely there will be cultural exchange
-		for c := 0; c < *interactions; c++ {
-			// randomly choose one cell
-			r := rand.Intn(*width * *width)
-			if cells[r].getRGB() != 0x0000 {
-				// find all its neighbours
-				neighbours := findNeighboursIndex(r)
-				for _, neighbour := range neighbours {
-					if cells[neighbour].getRGB() != 0x0000 {
-						// cultural differences between the neighbour
-						d := diff(r, neighbour)
-						// probability of a cultural exchange happening
-						probability := 1 - float64(d)/96.0
-						dp := rand.Float64()
-						// cultural exchange happens
-						if dp < probability {
-							// randomly select one of the features
-							i := rand.Intn(6)
-							if d != 0 {
-								var rp int
-								// randomly select either trait to be replaced by the neighbour's
-								if rand.Intn(1) == 0 {
-									replacement := extract(cells[r].getRGB(), uint(i))
-									rp = replace(cells[neighbour].getRGB(), replacement, uint(i))
-								} else {
-									replacement := extract(cells[neighbour].getRGB(), uint(i))
-									rp = replace(cells[r].getRGB(), replacement, uint(i))
-								}
-								cells[neighbour].setRGB(rp)
-								chg++
-							}
-						}
-
-					}
+		// get them to have cultural exchange with their neighbours, unless
+		// the interactions of the tick are replayed from a recording, then
+		// drift, migrate and turn over the cultures. In the per-populated
+		// mode the number of interactions follows the population, which is
+		// only counted for the benchmark
+		if *nMode != "per-populated" {
+			attempts += *interactions
+		} else if *bench {
+			attempts += *interactions * sim.PopulatedCount()
+		}
+		if recording != nil {
+			recording.tick = t
+		}
+		var result culture.StepResult
+		if replay != nil {
+			replayed, err := replay.next(t)
+			if err == nil {
+				result, err = sim.StepReplay(replayed)
+			}
+			if err != nil {
+				closeTerminal()
+				log.Fatal(err)
+			}
+		} else if result, err = sim.Step(); err != nil {
+			closeTerminal()
+			log.Fatal(err)
+		}
+		totalExchanges += result.Exchanges
+		totalMedia += result.MediaExchanges
+		// the grid is measured once all the changes for this tick are done
+		values := result.Metrics
+
+		drawInto(img, sim)
+		shown = t
+		// what is shown is scaled down to fit, the saved images are full size
+		if !*headless {
+			preview = downsample(img, *maxPixels, preview)
+			printImage(preview.SubImage(preview.Rect))
+		}
+		if server != nil && t%*serveEvery == 0 {
+			preview = downsample(img, *maxPixels, preview)
+			server.broadcast(preview)
+		}
+		if *saveFrames && t%*frameEvery == 0 {
+			saveImage(fmt.Sprintf("%s/%06d.png", framesDir, t), img)
+		}
+		// sampling the frames keeps the memory used by long simulations bounded
+		if *saveGif && t%*gifEvery == 0 {
+			animation.Image = append(animation.Image, paletted(img, culturePalette(sim)))
+			animation.Delay = append(animation.Delay, *gifDelay)
+		}
+		if !*bench && !*quiet {
+			// the seed and the grid are shown so that a run can be noted and rerun
+			fmt.Printf("\nSeed: %d, grid: %dx%d, %s neighbourhood of radius %d\n", *seed, sim.Width, sim.Height, *neighbourhood, *radius)
+			fmt.Println("Number of cultural interactions per simulation tick:", *interactions)
+			fmt.Printf("Simulation ticks: %d/%d", t, *numTicks)
+			if t < *burnin {
+				fmt.Print(" (burn-in)")
+			}
+			fmt.Printf("\nSimulation coverage: %2.0f%% (%d/%d cells populated)", *coverage*100, sim.PopulatedCount(), len(sim.Cells))
+			if ticker != nil {
+				fmt.Printf("\nTick rate: %d ticks/s", *fps)
+			} else {
+				fmt.Printf("\nTick delay: %v (+/- to change)", keys.delay)
+			}
+
+			fmt.Print("\n\n")
+			for i, m := range sim.Metrics {
+				fmt.Printf("%-33s: %v\n", culture.MetricLabel(m), values[i])
+			}
+			if sparklines {
+				fmt.Println()
+			}
+			for i, m := range sim.Metrics {
+				if trends[i] == nil {
+					continue
+				}
+				if trends[i] = append(trends[i], values[i]); len(trends[i]) > sparklineWidth {
+					trends[i] = trends[i][1:]
 				}
+				fmt.Printf("%-33s: %s\n", m.Name(), sparkline(trends[i]))
 			}
+			fmt.Println("\nCtrl-Q to quit simulation and save data. Space to pause. S to save a snapshot. R to restart.")
+			if time.Now().Before(keys.noticeUntil) {
+				fmt.Println(keys.notice)
+			}
+		}
+		// the grid still evolves during the burn-in, but is only logged after it
+		if t >= *burnin {
+			sim.LogMetrics(values)
+			if jsonFile != nil {
+				if err := sim.WriteMetricsJSON(jsonFile, t, values); err != nil {
+					log.Println("failed writing metrics:", err)
+				}
+			}
+		}
+
+		if *injectAt > 0 && t >= *injectAt && fixation < 0 && sim.CultureFraction(injected) == 1 {
+			fixation = t
+		}
+		if monoculture < 0 && sim.Monoculture() {
+			monoculture = t
+		}
+
+		// stop once the grid has reached an absorbing state
+		if *stopOnConvergence && sim.ActiveLinkCount() == 0 {
+			converged = t
+			endSim = true
+		}
+
+		if *checkpointEvery > 0 && (t+1)%*checkpointEvery == 0 {
+			cp := checkpoint{Config: configParams(), Tick: t, Exchanges: totalExchanges, Media: totalMedia,
+				Fixation: fixation, Monoculture: monoculture, State: sim.State()}
+			if err := saveCheckpoint(filepath.Join(*outputDir, simName+".checkpoint.json"), cp); err != nil {
+				log.Println("failed saving checkpoint:", err)
+			}
+		}
+
+		// pause at the target tick, leaving the grid on screen until the
+		// spacebar is pressed. Without a display there is nothing to pause,
+		// so take a snapshot of the grid at that tick instead
+		if *pauseAt > 0 && t == *pauseAt && *headless {
+			saveImage(filepath.Join(*outputDir, fmt.Sprintf("%s-t%d.png", simName, t)), img)
+			saveGrid(sim, filepath.Join(*outputDir, fmt.Sprintf("grid-%s-t%d.csv", simName, t)))
+		}
+		if pausesAt(t) {
+			keys.paused = true
+		}
+		// a ticker keeps the ticks evenly spaced however long each one takes
+		wake := time.After(keys.delay)
+		if ticker != nil {
+			wake = ticker.C
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+		}
+
+		// while paused, keep the grid on screen and wait for the spacebar to
+		// resume or n to advance a single tick
+		if keys.paused && !endSim && ctx.Err() == nil {
+			fmt.Println("Paused at tick", t, "- space to resume, n to step one tick.")
+			keys.waitWhilePaused(ctx, events)
+		}
+	}
+	elapsed := time.Since(start)
+	closeTerminal()
+	if jsonFile != nil {
+		jsonFile.Close()
+	}
+	if recording != nil {
+		if err := recording.close(); err != nil {
+			log.Println("failed writing recording:", err)
+		}
+	}
+	// a cancelled replay leaves the rest of the recording
+	if replay != nil && ctx.Err() == nil {
+		if err := replay.close(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// the monoculture and the injection are recorded in the data log after the seed
+	extra := [][]string{{"monoculture-at", strconv.Itoa(monoculture)}}
+	if *injectAt > 0 {
+		extra = append(extra, []string{"inject-at", strconv.Itoa(*injectAt)},
+			[]string{"inject-culture", sim.FormatCulture(injected)}, []string{"fixation-at", strconv.Itoa(fixation)})
+	}
+	saveData(sim, simName, start, extra...)
+	if *ppmPath != "" {
+		savePPM(*ppmPath, img)
+	}
+	if *saveSVGImage {
+		saveSVG(filepath.Join(*outputDir, simName+".svg"), sim)
+	}
+	if *changeHeatmap {
+		saveChangeHeatmap(sim, simName)
+	}
+	if *activityMap {
+		counts := make([]int, len(sim.Cells))
+		for i, c := range sim.Cells {
+			counts[i] = c.Changes
+		}
+		saveImage(filepath.Join(*outputDir, "activity-"+simName+".png"), drawActivityMap(img.Rect.Dx(), img.Rect.Dy(), sim.Cells, counts))
+	}
+	if *saveGif {
+		saveAnimation(filepath.Join(*outputDir, simName+".gif"), &animation)
+	}
+	if *traps {
+		saveTraps(sim, simName)
+	}
+	if sim.MediaCulture != nil {
+		fmt.Printf("Cultural exchanges with neighbours: %d, with the media: %d\n", totalExchanges, totalMedia)
+	}
+	if converged >= 0 {
+		fmt.Println("Simulation converged at tick", converged)
+	}
+	if monoculture >= 0 {
+		fmt.Println("Simulation reached a monoculture at tick", monoculture)
+	}
+	if fixation >= 0 {
+		fmt.Printf("Injected culture %s took over the grid at tick %d\n", *injectCulture, fixation)
+	}
+	// the frozen domains tell an absorbing grid kept multicultural by its
+	// cultural traps from a grid that is still active
+	if frozen, active := trapSizes(sim); len(frozen) > 0 {
+		fmt.Printf("Frozen domains: %d, of sizes %d to %d (median %.0f), still active domains: %d\n",
+			len(frozen), frozen[0], frozen[len(frozen)-1], medianInt(frozen), active)
+	} else if active > 0 {
+		fmt.Printf("Frozen domains: none, still active domains: %d\n", active)
+	}
+	if *stubbornness != "" {
+		fmt.Printf("Correlation between stubbornness and domain size: %.3f\n", sim.StubbornnessCorrelation())
+	}
+	if *bench {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		seconds := elapsed.Seconds()
+		fmt.Printf("Simulation ran %d ticks in %v: %.1f ticks/s, %.0f interactions/s\n",
+			ticks, elapsed, float64(ticks)/seconds, float64(attempts)/seconds)
+		fmt.Printf("Memory obtained from the OS: %.1f MiB, heap in use: %.1f MiB\n",
+			float64(mem.Sys)/(1<<20), float64(mem.HeapInuse)/(1<<20))
+	}
+	fmt.Printf("Simulation ended.\n"+"Data written to %s \nLast grid saved to"+
+		" %s \nLast image saved to %s\n",
+		filepath.Join(*outputDir, "log-"+simName+".csv"), filepath.Join(*outputDir, "cell-"+simName+".csv"),
+		filepath.Join(*outputDir, simName+".png"))
+}
+
+// whether the simulation pauses at the tick, which is only at the tick of
+// -pause-at and with a display to resume it from
+func pausesAt(t int) bool {
+	return *pauseAt > 0 && t == *pauseAt && !*headless
+}
+
+// the keys controlling the simulation on the terminal. Space pauses and
+// resumes the simulation, and while paused n steps through it one tick at a
+// time. + and - speed up and slow down the simulation by halving and doubling
+// the delay between ticks, s saves a snapshot of the grid on screen and r
+// restarts the simulation
+type controls struct {
+	paused  bool
+	restart bool // restart the simulation before the next tick
+	delay   time.Duration
+
+	// confirmation of the last key, shown under the metrics until it expires
+	notice      string
+	noticeUntil time.Time
 
-			// calculate the average distance between all features and the number of unique cultures
-			dist = featureDistAvg()
-			uniq = similarCount()
+	cancel   func()        // cancel the simulation
+	snapshot func() string // save a snapshot of the grid on screen, returning the notice of where to
+	fixed    bool          // the simulation is recorded or replayed, and cannot be restarted
+}
+
+// handle a key pressed on the terminal. Returns true to step a tick
+func (c *controls) handleEvent(ev termbox.Event) (step bool) {
+	if ev.Type != termbox.EventKey {
+		return
+	}
+	switch {
+	// termbox puts the terminal in raw mode where Ctrl-C is a key event
+	// instead of an interrupt, so it cancels the simulation like Ctrl-Q
+	case ev.Key == termbox.KeyCtrlQ || ev.Key == termbox.KeyCtrlC:
+		c.cancel()
+	case ev.Key == termbox.KeySpace:
+		c.paused = !c.paused
+	case ev.Ch == 'n':
+		return c.paused
+	case ev.Ch == '+':
+		if c.delay /= 2; c.delay < 10*time.Millisecond {
+			c.delay = 0
+		}
+	case ev.Ch == '-':
+		if c.delay *= 2; c.delay == 0 {
+			c.delay = 10 * time.Millisecond
+		}
+	case ev.Ch == 's':
+		c.notify(c.snapshot())
+	case ev.Ch == 'r':
+		if c.fixed {
+			c.notify("A recorded or replayed simulation cannot be restarted")
+			return
 		}
+		// while paused the fresh grid is stepped to its first tick
+		c.restart = true
+		return c.paused
+	}
+	return
+}
+
+// show the notice under the metrics for a while and print it
+func (c *controls) notify(notice string) {
+	c.notice, c.noticeUntil = notice, time.Now().Add(3*time.Second)
+	fmt.Println(notice)
+}
+
+// wait while the simulation is paused for the spacebar to resume it or n to
+// step a single tick, handling the keys as they are pressed, or until ctx is
+// done
+func (c *controls) waitWhilePaused(ctx context.Context, events <-chan termbox.Event) {
+	for c.paused && ctx.Err() == nil {
+		select {
+		case ev := <-events:
+			if c.handleEvent(ev) {
+				return
+			}
+		case <-ctx.Done():
+		}
+	}
+}
 
-		img = draw(*width*CELLSIZE+CELLSIZE, *width*CELLSIZE+CELLSIZE, cells)
-		printImage(img.SubImage(img.Rect))
-		fmt.Println("\nNumber of cultural interactions per simulation tick:", *interactions)
-		fmt.Printf("Simulation ticks: %d/%d", t, *numTicks)
-		fmt.Printf("\nSimulation coverage: %2.0f%%", *coverage*100)
+// the name the files of the simulation are saved under, from its parameters
+// and seed
+func simulationName(sim *culture.Sim) string {
+	if sim.Height != sim.Width {
+		return fmt.Sprintf("n%d-t%d-w%d-h%d-c%1.1f-s%d", *interactions, *numTicks, *width, sim.Height, *coverage, *seed)
+	}
+	return fmt.Sprintf("n%d-t%d-w%d-c%1.1f-s%d", *interactions, *numTicks, *width, *coverage, *seed)
+}
 
-		fmt.Println("\n\naverage distance between cultures:", dist,
-			"\nnumber of unique cultures        :", uniq,
-			"\nnumber of cultural exchanges     :", chg)
-		fmt.Println("\nCtrl-Q to quit simulation and save data.")
-		fdistances = append(fdistances, strconv.Itoa(dist))
-		changes = append(changes, strconv.Itoa(chg/(*width)))
-		uniques = append(uniques, strconv.Itoa(uniq))
+// the parameters of the simulation, from the flags
+func simParams() (culture.Params, error) {
+	var mediaCulture []int
+	if *media != "" {
+		traits, err := culture.ParseTraits(*media, *features, *traits)
+		if err != nil {
+			return culture.Params{}, fmt.Errorf("invalid media culture %q: %s", *media, err)
+		}
+		mediaCulture = traits
 	}
-	termbox.Close()
+	return culture.Params{
+		Width:             *width,
+		Height:            *height,
+		Coverage:          *coverage,
+		ExactCoverage:     *exactCoverage,
+		Init:              *initPattern,
+		InitCultures:      *initCultures,
+		Interactions:      *interactions,
+		NMode:             *nMode,
+		Features:          *features,
+		Traits:            *traits,
+		Neighbourhood:     *neighbourhood,
+		Update:            *update,
+		Radius:            *radius,
+		Wrap:              *wrap,
+		WrapX:             *wrapX,
+		WrapY:             *wrapY,
+		Workers:           *workers,
+		Drift:             *drift,
+		DriftPerFeature:   *driftPerFeature,
+		Migration:         *migration,
+		Turnover:          *turnover,
+		TurnoverMode:      *turnoverMode,
+		MediaCulture:      mediaCulture,
+		MediaStrength:     *mediaStrength,
+		InteractionCost:   *interactionCost,
+		Budget:            *budget,
+		ShuffleNeighbours: *shuffleNeighbours,
+		Distance:          *distance,
+		Rule:              *rule,
+		ProbFunc:          *probFunc,
+		ProbK:             *probK,
+		ProbShared:        *probShared,
+		Temperature:       *temperature,
+		Colonize:          *colonize,
+		Stubbornness:      *stubbornness,
+		Model:             *model,
+		Confidence:        *confidence,
+		ConvergenceRate:   *convergenceRate,
+		Strict:            *strict,
+	}, nil
+}
 
-	simName := fmt.Sprintf("n%d-t%d-w%d-c%1.1f", *interactions, *numTicks, *width, *coverage)
-	saveData(simName)
-	fmt.Printf("Simulation ended.\n"+"Data written to log-%s.csv \nLast grid saved to"+
-		" cells-%s.csv \nLast image saved to %s.png\n",
-		simName, simName, simName)
+// create the initial population, or continue from a saved grid or image
+func populate(sim *culture.Sim) error {
+	switch {
+	case *loadPath != "":
+		return loadGrid(sim, *loadPath)
+	case *imageInit != "":
+		return loadImage(sim, *imageInit)
+	}
+	sim.CreatePopulation()
+	return nil
 }
 
-// save simulation data
-func saveData(name string) {
-	// simulation data
-	data := [][]string{
-		fdistances, // average feature distance
-		changes,    // number of changes
-		uniques}    // number of unique cultures
-	csvfile, err := os.Create(fmt.Sprintf("data/log-%s.csv", name))
+// save simulation data, with the provenance of the simulation at the start of
+// the data log and any extra rows recorded at its end
+func saveData(sim *culture.Sim, name string, started time.Time, extra ...[]string) {
+	csvfile, err := os.Create(filepath.Join(*outputDir, fmt.Sprintf("log-%s.csv", name)))
 	if err != nil {
 		log.Fatalf("failed creating file: %s", err)
 	}
+	if err := writeMetadata(csvfile, started); err != nil {
+		log.Fatalf("failed writing file: %s", err)
+	}
 	csvwriter := csv.NewWriter(csvfile)
 
-	for _, line := range data {
-		_ = csvwriter.Write([]string(line))
+	if *csvFormat == "long" {
+		// the seed and the extra rows don't fit the columns, so they go with
+		// the metadata before the header
+		for _, line := range extra {
+			fmt.Fprintf(csvfile, "# %s=%s\n", line[0], strings.Join(line[1:], ","))
+		}
+		_ = csvwriter.Write([]string{"tick", "metric", "value"})
+		// the first value of every metric is logged at the end of the burn-in
+		for i := 1; len(sim.MetricData) > 0 && i < len(sim.MetricData[0]); i++ {
+			tick := strconv.Itoa(*burnin + i - 1)
+			for _, line := range sim.MetricData {
+				_ = csvwriter.Write([]string{tick, line[0], line[i]})
+			}
+		}
+	} else {
+		// the first row has the tick of every column of values
+		if len(sim.MetricData) > 0 {
+			_ = csvwriter.Write(tickRow(len(sim.MetricData[0]) - 1))
+		}
+		for _, line := range sim.MetricData {
+			_ = csvwriter.Write([]string(line))
+		}
+		// record the seed so the simulation can be rerun exactly; it goes last
+		// as readers take the number of columns from the first row
+		_ = csvwriter.Write([]string{"seed", strconv.FormatInt(*seed, 10)})
+		for _, line := range extra {
+			_ = csvwriter.Write(line)
+		}
 	}
 	csvwriter.Flush()
 	csvfile.Close()
 
 	// snapshot of grid at the end of the simulation
-	grid := make(map[int]int)
-	for _, c := range cells {
-		grid[c.getRGB()]++
+	writeHistogram(filepath.Join(*outputDir, fmt.Sprintf("cell-%s.csv", name)), cultureHistogram(sim))
+
+	// size distribution of the connected cultural domains at the end of the
+	// simulation, the number of domains of each size
+	domains := make(map[int]int)
+	for _, size := range sim.DomainSizes() {
+		domains[size]++
+	}
+	sizes := make([]int, 0, len(domains))
+	for size := range domains {
+		sizes = append(sizes, size)
 	}
-	cellsfile, err := os.Create(fmt.Sprintf("data/cell-%s.csv", name))
+	sort.Ints(sizes)
+	domainsfile, err := os.Create(filepath.Join(*outputDir, fmt.Sprintf("domains-%s.csv", name)))
 	if err != nil {
 		log.Fatalf("failed creating file: %s", err)
 	}
-	csvwriter = csv.NewWriter(cellsfile)
-	for k, v := range grid {
-		_ = csvwriter.Write([]string{strconv.Itoa(k), strconv.Itoa(v)})
+	csvwriter = csv.NewWriter(domainsfile)
+	_ = csvwriter.Write([]string{"size", "count"})
+	for _, size := range sizes {
+		_ = csvwriter.Write([]string{strconv.Itoa(size), strconv.Itoa(domains[size])})
 	}
 	csvwriter.Flush()
-	csvfile.Close()
+	domainsfile.Close()
+
+	// full grid at the end of the simulation, which can be loaded with -load
+	saveGrid(sim, filepath.Join(*outputDir, fmt.Sprintf("grid-%s.csv", name)))
 
 	// save the last image of the grid
-	saveImage("data/"+name+".png", img)
+	saveImage(filepath.Join(*outputDir, name+".png"), img)
+}
+
+// the number of cells of every culture on the grid, with the empty cells
+// counted under the empty culture
+func cultureHistogram(sim *culture.Sim) map[string]int {
+	h := make(map[string]int)
+	for _, c := range sim.Cells {
+		h[sim.FormatCulture(c.Culture)]++
+	}
+	return h
+}
+
+// save a histogram as a CSV, one row of every value with its count
+func writeHistogram(path string, h map[string]int) {
+	histfile, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("failed creating file: %s", err)
+	}
+	csvwriter := csv.NewWriter(histfile)
+	for k, v := range h {
+		_ = csvwriter.Write([]string{k, strconv.Itoa(v)})
+	}
+	csvwriter.Flush()
+	histfile.Close()
+}
+
+// number of the most recent ticks drawn in a sparkline
+const sparklineWidth = 60
+
+// draw the values as a line of block characters, scaled so that the lowest
+// value is the shortest block and the highest the tallest
+func sparkline(values []float64) string {
+	blocks := []rune("▁▂▃▄▅▆▇█")
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		min, max = math.Min(min, v), math.Max(max, v)
+	}
+	line := make([]rune, len(values))
+	for i, v := range values {
+		level := 0
+		if max > min {
+			level = int((v - min) / (max - min) * float64(len(blocks)-1))
+		}
+		line[i] = blocks[level]
+	}
+	return string(line)
+}
+
+// the culture integer of the traits of the injected culture in the
+// simulation, Empty without an injected culture. The traits are checked when
+// the flags are read
+func injectedCulture(sim *culture.Sim, traits []int) int {
+	if traits == nil {
+		return culture.Empty
+	}
+	c, err := sim.Culture(traits)
+	if err != nil {
+		return culture.Empty
+	}
+	return c
+}
+
+// save the number of times each cell changed culture over the simulation,
+// as a CSV laid out like the grid and as a grayscale image
+func saveChangeHeatmap(sim *culture.Sim, name string) {
+	heatfile, err := os.Create(filepath.Join(*outputDir, fmt.Sprintf("changes-%s.csv", name)))
+	if err != nil {
+		log.Fatalf("failed creating file: %s", err)
+	}
+	csvwriter := csv.NewWriter(heatfile)
+	for y := 0; y < sim.Height; y++ {
+		row := make([]string, sim.Width)
+		for x := range row {
+			row[x] = strconv.Itoa(sim.Cells[sim.CellIndex(x, y)].Changes)
+		}
+		_ = csvwriter.Write(row)
+	}
+	csvwriter.Flush()
+	heatfile.Close()
+
+	counts := make([]int, len(sim.Cells))
+	for i, c := range sim.Cells {
+		counts[i] = c.Changes
+	}
+	heatmap := drawHeatmap(img.Rect.Dx(), img.Rect.Dy(), sim.Cells, counts)
+	saveImage(filepath.Join(*outputDir, "changes-"+name+".png"), heatmap)
+}
+
+// the sorted sizes of the frozen domains of the grid, and the number of
+// domains that are still active
+func trapSizes(sim *culture.Sim) (frozen []int, active int) {
+	sizes := sim.DomainSizes()
+	for label, isFrozen := range sim.FrozenDomains() {
+		if isFrozen {
+			frozen = append(frozen, sizes[label])
+		} else {
+			active++
+		}
+	}
+	sort.Ints(frozen)
+	return
+}
+
+// median of the sorted sizes
+func medianInt(sorted []int) float64 {
+	values := make([]float64, len(sorted))
+	for i, v := range sorted {
+		values[i] = float64(v)
+	}
+	return median(values)
+}
+
+// save the size distribution of the frozen and the still active domains at
+// the end of the simulation, the number of domains of each size that are
+// frozen and that are still active
+func saveTraps(sim *culture.Sim, name string) {
+	sizes := sim.DomainSizes()
+	frozen, active := make(map[int]int), make(map[int]int)
+	for label, isFrozen := range sim.FrozenDomains() {
+		if isFrozen {
+			frozen[sizes[label]]++
+		} else {
+			active[sizes[label]]++
+		}
+	}
+	var distinct []int
+	for size := range frozen {
+		distinct = append(distinct, size)
+	}
+	for size := range active {
+		if frozen[size] == 0 {
+			distinct = append(distinct, size)
+		}
+	}
+	sort.Ints(distinct)
+	trapsfile, err := os.Create(filepath.Join(*outputDir, fmt.Sprintf("traps-%s.csv", name)))
+	if err != nil {
+		log.Fatalf("failed creating file: %s", err)
+	}
+	csvwriter := csv.NewWriter(trapsfile)
+	_ = csvwriter.Write([]string{"size", "frozen", "active"})
+	for _, size := range distinct {
+		_ = csvwriter.Write([]string{strconv.Itoa(size), strconv.Itoa(frozen[size]), strconv.Itoa(active[size])})
+	}
+	csvwriter.Flush()
+	trapsfile.Close()
+}
+
+// save a snapshot of the simulation at the tick, the image of the grid, the
+// full grid, the number of cells of every culture and the metrics of the
+// grid, to files named after the simulation and the tick in the output
+// directory. Returns the name the files share
+func saveSnapshot(sim *culture.Sim, name string, tick int) string {
+	stem := fmt.Sprintf("snapshot-%s-t%d", name, tick)
+	saveImage(filepath.Join(*outputDir, stem+".png"), img)
+	saveGrid(sim, filepath.Join(*outputDir, "grid-"+stem+".csv"))
+	writeHistogram(filepath.Join(*outputDir, "cell-"+stem+".csv"), cultureHistogram(sim))
+
+	// the metrics of the grid are laid out like a single tick of the data log
+	metricsfile, err := os.Create(filepath.Join(*outputDir, "metrics-"+stem+".csv"))
+	if err != nil {
+		log.Fatalf("failed creating file: %s", err)
+	}
+	csvwriter := csv.NewWriter(metricsfile)
+	_ = csvwriter.Write([]string{"tick", strconv.Itoa(tick)})
+	for i, v := range sim.MeasureMetrics() {
+		_ = csvwriter.Write([]string{sim.Metrics[i].Name(), strconv.FormatFloat(v, 'f', -1, 64)})
+	}
+	csvwriter.Flush()
+	metricsfile.Close()
+	return stem
+}
+
+// the row of the ticks of the count values logged from the end of the
+// burn-in, starting with its name like the rows of the metrics
+func tickRow(count int) []string {
+	row := []string{"tick"}
+	for i := 0; i < count; i++ {
+		row = append(row, strconv.Itoa(*burnin+i))
+	}
+	return row
+}
+
+// save the culture of every cell of the grid, one row per cell with its
+// column x and row y on the grid
+func saveGrid(sim *culture.Sim, path string) {
+	gridfile, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("failed creating file: %s", err)
+	}
+	csvwriter := csv.NewWriter(gridfile)
+	_ = csvwriter.Write([]string{"index", "x", "y", "rgb"})
+	for i, c := range sim.Cells {
+		_ = csvwriter.Write([]string{strconv.Itoa(i), strconv.Itoa(i / sim.Height), strconv.Itoa(i % sim.Height),
+			sim.FormatCulture(c.Culture)})
+	}
+	csvwriter.Flush()
+	gridfile.Close()
+}
+
+// load the grid saved by saveGrid as the population of the simulation
+func loadGrid(sim *culture.Sim, path string) error {
+	cultures, err := readGrid(sim, path)
+	if err != nil {
+		return err
+	}
+	sim.PopulateGrid(cultures)
+	return nil
+}
+
+// read the cultures of the grid saved by saveGrid. The grid must have a
+// culture for every cell of the simulation, in order of index. The x and y
+// columns are checked against the index if there are any, grids saved
+// without them only have the index and rgb columns
+func readGrid(sim *culture.Sim, path string) ([]int, error) {
+	gridfile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer gridfile.Close()
+	rows, err := csv.NewReader(gridfile).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read grid %s: %s", path, err)
+	}
+	positioned := len(rows) > 0 && strings.Join(rows[0], ",") == "index,x,y,rgb"
+	if !positioned && (len(rows) == 0 || strings.Join(rows[0], ",") != "index,rgb") {
+		return nil, fmt.Errorf("grid %s must start with an index,x,y,rgb or index,rgb header", path)
+	}
+	rgb := 1
+	if positioned {
+		rgb = 3
+	}
+	rows = rows[1:]
+	if len(rows) != sim.Width*sim.Height {
+		return nil, fmt.Errorf("grid %s has %d cells but a grid of %d by %d needs %d", path, len(rows), sim.Width, sim.Height, sim.Width*sim.Height)
+	}
+	cultures := make([]int, len(rows))
+	for i, row := range rows {
+		index, err := strconv.Atoi(row[0])
+		if err != nil || index != i {
+			return nil, fmt.Errorf("grid %s has index %q on row %d, expected %d", path, row[0], i+1, i)
+		}
+		if positioned && (row[1] != strconv.Itoa(i/sim.Height) || row[2] != strconv.Itoa(i%sim.Height)) {
+			return nil, fmt.Errorf("grid %s has cell %d at %s,%s, expected %d,%d", path, i, row[1], row[2], i/sim.Height, i%sim.Height)
+		}
+		cultures[i], err = sim.ParseCulture(row[rgb])
+		if err != nil {
+			return nil, fmt.Errorf("grid %s has invalid culture %q for cell %d", path, row[rgb], i)
+		}
+	}
+	return cultures, nil
 }
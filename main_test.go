@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// the test binary runs the program instead of the tests for runProgram, with
+// the flags of the program only
+func TestMain(m *testing.M) {
+	if os.Getenv("CULTURE_SIM_MAIN") == "1" {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// run the program headless with the arguments, saving its files in a
+// temporary directory, and return the directory and the output of the program
+func runProgram(t *testing.T, args ...string) (dir string, out []byte) {
+	t.Helper()
+	dir = t.TempDir()
+	cmd := exec.Command(os.Args[0], append([]string{"-headless", "-quiet", "-output-dir", dir}, args...)...)
+	cmd.Env = append(os.Environ(), "CULTURE_SIM_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("running with %v: %v\n%s", args, err, out)
+	}
+	return dir, out
+}
+
+// the one file of the directory matching the pattern
+func outputFile(t *testing.T, dir, pattern string) string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("%d files match %s in the output, want 1", len(matches), pattern)
+	}
+	return matches[0]
+}
+
+// replace the command line flags with a fresh set holding only the flags the
+// tests use, restoring the flags of the program after the test
+func useTestFlags(t *testing.T) {
+	saved, savedSeed, savedTicks := flag.CommandLine, seed, numTicks
+	savedPadding, savedPalette, savedBorders := padding, paletteName, borders
+	savedPauseAt, savedHeadless := pauseAt, headless
+	savedInteractions, savedWidth, savedCoverage := interactions, width, coverage
+	t.Cleanup(func() {
+		flag.CommandLine, seed, numTicks = saved, savedSeed, savedTicks
+		padding, paletteName, borders = savedPadding, savedPalette, savedBorders
+		pauseAt, headless = savedPauseAt, savedHeadless
+		interactions, width, coverage = savedInteractions, savedWidth, savedCoverage
+	})
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	seed = flag.Int64("seed", 0, "seed of the random number generator")
+	numTicks = flag.Int("t", 200, "number of simulation ticks")
+	padding = flag.Int("padding", 0, "gap in pixels between the cells")
+	paletteName = flag.String("palette", "rgb", "colors the cultures are drawn with")
+	borders = flag.Bool("borders", false, "draw black lines between cells of different cultures")
+	pauseAt = flag.Int("pause-at", 0, "pause the simulation when this tick is reached")
+	headless = flag.Bool("headless", false, "run without a display")
+	interactions = flag.Int("n", 100, "number of interactions per simulation tick")
+	width = flag.Int("w", 36, "width of the grid in cells")
+	coverage = flag.Float64("c", 1, "fraction of the grid populated")
+}
+
+func TestPausesAt(t *testing.T) {
+	useTestFlags(t)
+	tests := []struct {
+		pauseAt  int
+		headless bool
+		tick     int
+		want     bool
+	}{
+		{10, false, 9, false},
+		{10, false, 10, true},
+		{10, false, 11, false},
+		{10, true, 10, false},
+		{0, false, 0, false},
+	}
+	for _, tt := range tests {
+		*pauseAt, *headless = tt.pauseAt, tt.headless
+		if got := pausesAt(tt.tick); got != tt.want {
+			t.Errorf("pausesAt(%d) with -pause-at %d, headless %v is %v, want %v", tt.tick, tt.pauseAt, tt.headless, got, tt.want)
+		}
+	}
+}
+
+func TestPauseHaltsUntilSpace(t *testing.T) {
+	useTestFlags(t)
+	*pauseAt = 10
+	keys := &controls{}
+	events := make(chan termbox.Event)
+	// the ticks of the simulation loop, which pauses after the tick of
+	// -pause-at until the keys resume it
+	ticks := make(chan int)
+	go func() {
+		for tick := 0; tick < 20; tick++ {
+			ticks <- tick
+			if pausesAt(tick) {
+				keys.paused = true
+			}
+			if keys.paused {
+				keys.waitWhilePaused(context.Background(), events)
+			}
+		}
+		close(ticks)
+	}()
+	// the next tick the simulation runs within the wait, false if none
+	next := func(wait time.Duration) (int, bool) {
+		select {
+		case tick, ok := <-ticks:
+			return tick, ok
+		case <-time.After(wait):
+			return -1, false
+		}
+	}
+	for want := 0; want <= 10; want++ {
+		if tick, _ := next(time.Second); tick != want {
+			t.Fatalf("the simulation ran tick %d, want tick %d before the pause", tick, want)
+		}
+	}
+	// other keys leave the simulation paused at the tick
+	events <- termbox.Event{Type: termbox.EventKey, Ch: 'x'}
+	if tick, ok := next(50 * time.Millisecond); ok {
+		t.Fatalf("the simulation advanced to tick %d past the tick of -pause-at without the spacebar", tick)
+	}
+	// n steps a single tick and stays paused
+	events <- termbox.Event{Type: termbox.EventKey, Ch: 'n'}
+	if tick, _ := next(time.Second); tick != 11 {
+		t.Fatalf("n stepped to tick %d, want 11", tick)
+	}
+	if tick, ok := next(50 * time.Millisecond); ok {
+		t.Fatalf("the simulation advanced to tick %d after stepping a single tick", tick)
+	}
+	events <- termbox.Event{Type: termbox.EventKey, Key: termbox.KeySpace}
+	for want := 12; want < 20; want++ {
+		if tick, _ := next(time.Second); tick != want {
+			t.Fatalf("the simulation ran tick %d after the spacebar, want tick %d", tick, want)
+		}
+	}
+	if _, ok := next(time.Second); ok || keys.paused {
+		t.Error("the spacebar didn't resume the simulation to its end")
+	}
+}
+
+func TestPauseCancelled(t *testing.T) {
+	keys := &controls{paused: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	keys.waitWhilePaused(ctx, nil)
+}
+
+func TestRestartKey(t *testing.T) {
+	tests := []struct {
+		paused, fixed bool
+		step, restart bool
+	}{
+		// while paused the restarted grid is stepped to its first tick
+		{false, false, false, true},
+		{true, false, true, true},
+		{false, true, false, false},
+	}
+	for _, tt := range tests {
+		keys := &controls{paused: tt.paused, fixed: tt.fixed}
+		step := keys.handleEvent(termbox.Event{Type: termbox.EventKey, Ch: 'r'})
+		if step != tt.step || keys.restart != tt.restart {
+			t.Errorf("r paused %v, recorded %v steps %v and restarts %v, want %v and %v", tt.paused, tt.fixed, step, keys.restart, tt.step, tt.restart)
+		}
+	}
+}
+
+func TestPauseAtHeadlessSnapshot(t *testing.T) {
+	// the snapshot at tick 10 is the last grid of a run of ticks 0 to 10
+	dir, _ := runProgram(t, "-seed", "3", "-w", "8", "-t", "20", "-pause-at", "10")
+	last, _ := runProgram(t, "-seed", "3", "-w", "8", "-t", "11")
+	outputFile(t, dir, "*-t10.png")
+	snapshot, err := os.ReadFile(outputFile(t, dir, "grid-*-t10.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(outputFile(t, last, "grid-*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(snapshot) != string(want) {
+		t.Error("the snapshot at the tick of -pause-at isn't the grid of that tick")
+	}
+}
+
+// the names of the rows of a wide CSV file in the order they are in, after
+// the provenance, and the values of every row
+func readRows(t *testing.T, path string) (names []string, rows map[string][]string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows = make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		names = append(names, fields[0])
+		rows[fields[0]] = fields[1:]
+	}
+	return
+}
+
+func TestMetricsFlagLogsOnlySelected(t *testing.T) {
+	dir, _ := runProgram(t, "-seed", "1", "-w", "6", "-t", "3", "-metrics", "unique,largest")
+	rows, _ := readRows(t, outputFile(t, dir, "log-*.csv"))
+	if len(rows) < 4 || rows[0] != "tick" || rows[1] != "unique" || rows[2] != "largest" || rows[3] != "seed" {
+		t.Errorf("the data log has the rows %v, want tick, unique and largest before the seed", rows)
+	}
+}
+
+func TestBurninLog(t *testing.T) {
+	tests := []struct {
+		file string
+		args []string
+		rows []string
+	}{
+		{"log-*.csv", nil, []string{"unique", "largest"}},
+		{"ensemble-*.csv", []string{"-runs", "2"}, []string{"unique-mean", "unique-std", "largest-mean", "largest-std"}},
+	}
+	for _, tt := range tests {
+		args := append([]string{"-seed", "1", "-w", "6", "-t", "8", "-metrics", "unique,largest"}, tt.args...)
+		all, _ := runProgram(t, args...)
+		burnt, _ := runProgram(t, append(args, "-burnin", "3")...)
+		_, want := readRows(t, outputFile(t, all, tt.file))
+		_, got := readRows(t, outputFile(t, burnt, tt.file))
+		// the ticks after the burn-in are the same, and logged from its end
+		if ticks := strings.Join(got["tick"], ","); ticks != "3,4,5,6,7" {
+			t.Errorf("%s with a burn-in of 3 ticks logs the ticks %s, want 3 to 7", tt.file, ticks)
+		}
+		for _, row := range tt.rows {
+			if strings.Join(got[row], ",") != strings.Join(want[row][3:], ",") {
+				t.Errorf("%s with a burn-in of 3 ticks logs %s %v, want %v", tt.file, row, got[row], want[row][3:])
+			}
+		}
+	}
+}
+
+func TestBurninLongLog(t *testing.T) {
+	dir, _ := runProgram(t, "-seed", "1", "-w", "6", "-t", "6", "-metrics", "unique", "-burnin", "3", "-csv-format", "long")
+	names, _ := readRows(t, outputFile(t, dir, "log-*.csv"))
+	if got := strings.Join(names, ","); got != "tick,3,4,5" {
+		t.Errorf("the long data log with a burn-in of 3 ticks has the ticks %s, want tick,3,4,5", got)
+	}
+}
+
+func TestTinyGridRuns(t *testing.T) {
+	for _, width := range []string{"1", "2"} {
+		dir, _ := runProgram(t, "-seed", "1", "-w", width, "-t", "10")
+		_, rows := readRows(t, outputFile(t, dir, "log-*.csv"))
+		for name, values := range rows {
+			for _, v := range values {
+				if v == "NaN" || v == "+Inf" || v == "-Inf" {
+					t.Errorf("width %s: %s is %s", width, name, v)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestEndMessagePaths(t *testing.T) {
+	dir, out := runProgram(t, "-seed", "1", "-w", "6", "-t", "2")
+	for _, pattern := range []string{"log-*.csv", "cell-*.csv", "*.png"} {
+		path := outputFile(t, dir, pattern)
+		if !strings.Contains(string(out), path+" ") && !strings.Contains(string(out), path+"\n") {
+			t.Errorf("the end of the simulation doesn't show the path %s, shows\n%s", path, out)
+		}
+	}
+}
+
+func TestHeightFlag(t *testing.T) {
+	dir, _ := runProgram(t, "-seed", "1", "-w", "6", "-height", "4", "-t", "2")
+	// a grid of 6 by 4 cells of 10 pixels with a margin of half a cell around it
+	f, err := os.Open(outputFile(t, dir, "*-w6-h4-*.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 70 || config.Height != 50 {
+		t.Errorf("the image of a 6 by 4 grid is %dx%d, want 70x50", config.Width, config.Height)
+	}
+}
+
+func TestHelpFlag(t *testing.T) {
+	// -h is help, as it is for every program using the flag package
+	cmd := exec.Command(os.Args[0], "-h")
+	cmd.Env = append(os.Environ(), "CULTURE_SIM_MAIN=1")
+	out, _ := cmd.CombinedOutput()
+	if !strings.Contains(string(out), "-height") {
+		t.Errorf("-h doesn't show the help with -height, shows\n%s", out)
+	}
+}
+
+func TestWideCultureGridRoundTrip(t *testing.T) {
+	// 10 features of 100 traits are too wide to pack into a culture integer
+	wide := []string{"-seed", "1", "-w", "6", "-features", "10", "-traits", "100"}
+	dir, _ := runProgram(t, append(wide, "-t", "5")...)
+	saved := outputFile(t, dir, "grid-*.csv")
+	want, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ := runProgram(t, append(wide, "-t", "0", "-load", saved)...)
+	got, err := os.ReadFile(outputFile(t, loaded, "grid-*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("the loaded grid is saved as\n%s\nnot as the grid it was loaded from\n%s", got, want)
+	}
+}
+
+func TestSimulationNameHasSeed(t *testing.T) {
+	useTestFlags(t)
+	*seed, *numTicks, *width = 7, 50, 6
+	sim := testSim(t, 6, 1)
+	if name := simulationName(sim); name != "n100-t50-w6-c1.0-s7" {
+		t.Errorf("the simulation is named %s, want n100-t50-w6-c1.0-s7", name)
+	}
+	// a restart runs with the next seed, which its files are named after
+	*seed++
+	if name := simulationName(sim); name != "n100-t50-w6-c1.0-s8" {
+		t.Errorf("the restarted simulation is named %s, want n100-t50-w6-c1.0-s8", name)
+	}
+}
+
+func TestChangeHeatmapCountsChanges(t *testing.T) {
+	// every interaction is with the media, whose exchanges the program reports
+	args := []string{"-seed", "1", "-w", "6", "-height", "4", "-media", "0x000000", "-media-strength", "1"}
+	start, _ := runProgram(t, append(args, "-t", "0")...)
+	dir, out := runProgram(t, append(args, "-t", "20", "-change-heatmap")...)
+	before := gridCultures(t, outputFile(t, start, "grid-*.csv"))
+	after := gridCultures(t, outputFile(t, dir, "grid-*.csv"))
+
+	data, err := os.ReadFile(outputFile(t, dir, "changes-*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("the heatmap of a 6 by 4 grid has %d rows, want 4", len(lines))
+	}
+	total := 0
+	for y, line := range lines {
+		fields := strings.Split(line, ",")
+		if len(fields) != 6 {
+			t.Fatalf("row %d of the heatmap of a 6 by 4 grid has %d cells, want 6", y, len(fields))
+		}
+		for x, field := range fields {
+			changes, err := strconv.Atoi(field)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// every change replaces a single trait
+			n := x*4 + y
+			differ := 0
+			for pos := uint(0); pos < 6; pos++ {
+				if (before[n]>>(4*pos))&0xF != (after[n]>>(4*pos))&0xF {
+					differ++
+				}
+			}
+			if changes < differ {
+				t.Errorf("cell %d,%d changed %d times but differs in %d features from its first culture", x, y, changes, differ)
+			}
+			total += changes
+		}
+	}
+	var neighbours, media int
+	for _, line := range strings.Split(string(out), "\n") {
+		if _, err := fmt.Sscanf(line, "Cultural exchanges with neighbours: %d, with the media: %d", &neighbours, &media); err == nil {
+			break
+		}
+	}
+	// the exchanges of a feature a cell already shares with the media are
+	// exchanges that don't change the cell
+	if total == 0 || total >= media {
+		t.Errorf("the heatmap counts %d changes of %d exchanges with the media, want fewer changes than exchanges", total, media)
+	}
+}
+
+// the cultures of the grid saved in the file, by index
+func gridCultures(t *testing.T, path string) []int {
+	t.Helper()
+	_, rows := readRows(t, path)
+	cultures := make([]int, len(rows)-1)
+	for index, row := range rows {
+		if index == "index" {
+			continue
+		}
+		n, err := strconv.Atoi(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cultures[n], err = strconv.Atoi(row[len(row)-1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cultures
+}
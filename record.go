@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/sausheong/culture_sim/culture"
+)
+
+// the header of a recording of the interactions, one line for every
+// interaction with the tick it happened in, the cell that initiated it, the
+// neighbour it interacted with (-1 for the mass media), the feature whose
+// trait was copied (-1 for none, or the whole culture of a colonization), 1
+// if the trait went from the neighbour to the cell instead of the other way
+// and 1 if it changed a culture
+var recordHeader = []string{"tick", "cell", "neighbour", "feature", "direction", "fired"}
+
+// the flags that change the grid in ways a recording of the interactions
+// doesn't replay, or split the interactions across workers in an order that
+// can't be recorded
+func unrecordable() error {
+	switch {
+	case *model == "deffuant":
+		return fmt.Errorf("the interactions of the deffuant model can't be recorded or replayed")
+	case *workers > 1:
+		return fmt.Errorf("the interactions of several workers can't be recorded or replayed")
+	case *drift > 0 || *driftPerFeature != "" || *migration > 0 || *turnover > 0 || *injectAt > 0:
+		return fmt.Errorf("drift, migration, turnover and injection can't be recorded or replayed")
+	case *runs > 1 || *sweep != "" || *restorePath != "":
+		return fmt.Errorf("only a single simulation from the start can be recorded or replayed")
+	}
+	return nil
+}
+
+// a recording of the interactions being written as a CSV
+type recorder struct {
+	file   *os.File
+	writer *csv.Writer
+	tick   int // tick of the interactions being recorded
+}
+
+// start recording the interactions of the simulation to a CSV file
+func startRecording(sim *culture.Sim, path string) (*recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	rec := &recorder{file: file, writer: csv.NewWriter(file)}
+	_ = rec.writer.Write(recordHeader)
+	sim.Record = func(in culture.Interaction) {
+		_ = rec.writer.Write([]string{strconv.Itoa(rec.tick), strconv.Itoa(in.Cell), strconv.Itoa(in.Neighbour),
+			strconv.Itoa(in.Feature), flagInt(in.Reversed), flagInt(in.Fired)})
+	}
+	return rec, nil
+}
+
+// finish the recording
+func (rec *recorder) close() error {
+	rec.writer.Flush()
+	if err := rec.writer.Error(); err != nil {
+		rec.file.Close()
+		return err
+	}
+	return rec.file.Close()
+}
+
+// 1 for true and 0 for false
+func flagInt(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// a recording of the interactions being read back tick by tick
+type replayer struct {
+	path    string
+	file    *os.File
+	reader  *csv.Reader
+	line    int
+	pending []string // first row of the next tick, nil if none has been read
+}
+
+// open a recording of the interactions to replay
+func openReplay(path string) (*replayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	rep := &replayer{path: path, file: file, reader: csv.NewReader(file), line: 1}
+	rep.reader.FieldsPerRecord = len(recordHeader)
+	header, err := rep.reader.Read()
+	if err != nil || fmt.Sprint(header) != fmt.Sprint(recordHeader) {
+		file.Close()
+		return nil, fmt.Errorf("recording %s must start with a %v header", path, recordHeader)
+	}
+	return rep, nil
+}
+
+// the recorded interactions of the tick, in the order they happened. The
+// ticks of the recording must go up
+func (rep *replayer) next(tick int) ([]culture.Interaction, error) {
+	var interactions []culture.Interaction
+	for {
+		row := rep.pending
+		rep.pending = nil
+		if row == nil {
+			var err error
+			if row, err = rep.reader.Read(); err == io.EOF {
+				return interactions, nil
+			} else if err != nil {
+				return nil, fmt.Errorf("cannot read recording %s: %s", rep.path, err)
+			}
+			rep.line++
+		}
+		var values [6]int
+		for i, field := range row {
+			v, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("recording %s has an invalid %s %q on line %d", rep.path, recordHeader[i], field, rep.line)
+			}
+			values[i] = v
+		}
+		if values[0] < tick {
+			return nil, fmt.Errorf("recording %s has tick %d after tick %d on line %d", rep.path, values[0], tick, rep.line)
+		}
+		if values[0] > tick {
+			rep.pending = row
+			return interactions, nil
+		}
+		interactions = append(interactions, culture.Interaction{Cell: values[1], Neighbour: values[2], Feature: values[3],
+			Reversed: values[4] == 1, Fired: values[5] == 1})
+	}
+}
+
+// finish the replay, with an error if the recording has interactions after
+// the last tick that was replayed
+func (rep *replayer) close() error {
+	defer rep.file.Close()
+	if rep.pending != nil {
+		return fmt.Errorf("recording %s has interactions after the last tick replayed, from tick %s", rep.path, rep.pending[0])
+	}
+	if _, err := rep.reader.Read(); err != io.EOF {
+		return fmt.Errorf("recording %s has interactions after the last tick replayed", rep.path)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the page served to watch the simulation, showing every frame pushed over
+// the WebSocket as it arrives
+const servePage = `<!DOCTYPE html>
+<html>
+<head><title>Culture simulation</title></head>
+<body style="background:#222;color:#ccc;font-family:sans-serif">
+<p id="status">Connecting...</p>
+<img id="grid" style="image-rendering:pixelated">
+<script>
+var ws = new WebSocket("ws://" + location.host + "/ws");
+ws.onopen = function() { document.getElementById("status").textContent = "Connected"; };
+ws.onclose = function() { document.getElementById("status").textContent = "Simulation ended"; };
+ws.onmessage = function(e) { document.getElementById("grid").src = "data:image/png;base64," + e.data; };
+</script>
+</body>
+</html>
+`
+
+// GUID appended to the key of a WebSocket handshake, from RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// a server pushing the images of the grid to the browsers watching it
+type frameServer struct {
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+}
+
+// start serving the page to watch the simulation and the WebSocket of the
+// frames on the address, such as :8080
+func startServer(addr string) (*frameServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &frameServer{clients: make(map[chan []byte]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, servePage)
+	})
+	mux.HandleFunc("/ws", server.serveWebSocket)
+	go http.Serve(listener, mux)
+	fmt.Printf("Watch the simulation at http://%s/\n", listener.Addr())
+	return server, nil
+}
+
+// upgrade the request to a WebSocket and push the frames to it until either
+// side closes it
+func (server *frameServer) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket", http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot upgrade to a WebSocket", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(accept[:]))
+	if rw.Flush() != nil {
+		return
+	}
+
+	// a slow browser only misses frames instead of holding up the simulation
+	frames := make(chan []byte, 1)
+	server.mu.Lock()
+	server.clients[frames] = true
+	server.mu.Unlock()
+	defer func() {
+		server.mu.Lock()
+		delete(server.clients, frames)
+		server.mu.Unlock()
+	}()
+
+	// the browser only sends control frames. Its close frame is answered
+	// with a close frame echoing the status code, as RFC 6455 asks, and
+	// reading stops when it closes the connection
+	closing := make(chan []byte, 1)
+	go func() {
+		defer close(closing)
+		for {
+			opcode, payload, err := readFrame(rw.Reader)
+			if err != nil {
+				return
+			}
+			if opcode == opClose {
+				if len(payload) > 2 {
+					payload = payload[:2]
+				}
+				closing <- payload
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case frame := <-frames:
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := writeFrame(rw.Writer, opText, frame); err != nil {
+				return
+			}
+		case status, ok := <-closing:
+			if ok {
+				conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+				writeFrame(rw.Writer, opClose, status)
+			}
+			return
+		}
+	}
+}
+
+// opcodes of the WebSocket frames, from RFC 6455
+const (
+	opText  = 0x1
+	opClose = 0x8
+)
+
+// read a WebSocket frame from the browser and return its opcode and its
+// unmasked payload. The browser only sends small control frames, so the
+// payload of a larger frame is skipped
+func readFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	opcode = header[0] & 0x0F
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var n16 uint16
+		err = binary.Read(r, binary.BigEndian, &n16)
+		n = uint64(n16)
+	case 127:
+		err = binary.Read(r, binary.BigEndian, &n)
+	}
+	if err != nil {
+		return
+	}
+	var mask [4]byte
+	masked := header[1]&0x80 != 0
+	if masked {
+		if _, err = io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+	}
+	if n > 125 {
+		_, err = io.CopyN(io.Discard, r, int64(n))
+		return opcode, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// write the payload as a single unmasked and final WebSocket frame
+func writeFrame(w *bufio.Writer, opcode byte, payload []byte) error {
+	w.WriteByte(0x80 | opcode)
+	switch n := len(payload); {
+	case n < 126:
+		w.WriteByte(byte(n))
+	case n <= 0xFFFF:
+		w.WriteByte(126)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(127)
+		binary.Write(w, binary.BigEndian, uint64(n))
+	}
+	w.Write(payload)
+	return w.Flush()
+}
+
+// push the image to every browser watching, as a base64 PNG. The image is
+// encoded before returning so its buffer can be drawn over again
+func (server *frameServer) broadcast(img image.Image) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.clients) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	frame := []byte(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	for frames := range server.clients {
+		// replace a frame the browser hasn't taken yet with the newer one
+		select {
+		case <-frames:
+		default:
+		}
+		frames <- frame
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a masked WebSocket frame as a browser sends it
+func maskedFrame(opcode byte, payload []byte) []byte {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// open a WebSocket to the server of the frames
+func dialWebSocket(t *testing.T, server *frameServer) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(server.serveWebSocket))
+	t.Cleanup(ts.Close)
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("the handshake is answered with %s", resp.Status)
+	}
+	// the accept key of the sample handshake of RFC 6455
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("the handshake is accepted with %s", accept)
+	}
+	return conn, r
+}
+
+func TestWebSocketClose(t *testing.T) {
+	server := &frameServer{clients: make(map[chan []byte]bool)}
+	conn, r := dialWebSocket(t, server)
+	// a normal closure, status 1000, with a reason
+	if _, err := conn.Write(maskedFrame(opClose, []byte{0x03, 0xE8, 'b', 'y', 'e'})); err != nil {
+		t.Fatal(err)
+	}
+	opcode, payload, err := readFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode != opClose || !bytes.Equal(payload, []byte{0x03, 0xE8}) {
+		t.Errorf("the close frame is answered with opcode %X and payload %X, want a close frame with the status 03E8", opcode, payload)
+	}
+	// and the server closes the connection
+	if _, err := r.ReadByte(); err == nil {
+		t.Error("the connection is still open after the close frames")
+	}
+}
+
+func TestWebSocketFrames(t *testing.T) {
+	server := &frameServer{clients: make(map[chan []byte]bool)}
+	conn, r := dialWebSocket(t, server)
+	// the browser's pings don't stop the frames
+	if _, err := conn.Write(maskedFrame(0x9, []byte("ping"))); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		server.mu.Lock()
+		clients := len(server.clients)
+		server.mu.Unlock()
+		if clients == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	frame := bytes.Repeat([]byte("x"), 300)
+	server.mu.Lock()
+	for frames := range server.clients {
+		frames <- frame
+	}
+	server.mu.Unlock()
+	opcode, _, err := readFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode != opText {
+		t.Errorf("the frame is sent with opcode %X, want a text frame", opcode)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		frame   []byte
+		opcode  byte
+		payload []byte
+	}{
+		{maskedFrame(opClose, []byte{0x03, 0xE8}), opClose, []byte{0x03, 0xE8}},
+		{maskedFrame(opClose, nil), opClose, []byte{}},
+		{[]byte{0x89, 0x02, 'h', 'i'}, 0x9, []byte("hi")},
+		{append([]byte{0x81, 126, 0x01, 0x00}, make([]byte, 256)...), opText, nil},
+	}
+	for _, tt := range tests {
+		opcode, payload, err := readFrame(bufio.NewReader(bytes.NewReader(tt.frame)))
+		if err != nil {
+			t.Fatalf("reading the frame %X: %v", tt.frame, err)
+		}
+		if opcode != tt.opcode || !bytes.Equal(payload, tt.payload) {
+			t.Errorf("the frame %X is read as opcode %X with the payload %X, want %X with %X", tt.frame, opcode, payload, tt.opcode, tt.payload)
+		}
+	}
+}
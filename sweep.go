@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sausheong/culture_sim/culture"
+)
+
+// names of the parameters that can be swept, for the flags with short names
+var sweepAliases = map[string]string{
+	"interactions": "n",
+	"ticks":        "t",
+	"width":        "w",
+	"coverage":     "c",
+}
+
+// sweep a parameter over a range given as name:start:end:step, such as
+// coverage:0.1:1.0:0.1, running the simulation the given number of times for
+// every value of the parameter with the same seeds as the ensemble. The final
+// number of unique cultures, the largest domain fraction and the tick at
+// which the grid converged are saved for every value, averaged over the runs
+func runSweep(ctx context.Context, spec, name string, runs int, injected []int) error {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 4 {
+		return fmt.Errorf("sweep %q must be name:start:end:step", spec)
+	}
+	param := parts[0]
+	if alias, ok := sweepAliases[param]; ok {
+		param = alias
+	}
+	if flag.Lookup(param) == nil || param == "sweep" {
+		return fmt.Errorf("sweep %q has an unknown parameter %q", spec, parts[0])
+	}
+	var bounds [3]float64
+	for i, s := range parts[1:] {
+		var err error
+		if bounds[i], err = strconv.ParseFloat(s, 64); err != nil {
+			return fmt.Errorf("sweep %q has an invalid number %q", spec, s)
+		}
+	}
+	start, end, step := bounds[0], bounds[1], bounds[2]
+	if step <= 0 || end < start {
+		return fmt.Errorf("sweep %q must have a positive step and end after it starts", spec)
+	}
+
+	sweepfile, err := os.Create(filepath.Join(*outputDir, fmt.Sprintf("sweep-%s.csv", name)))
+	if err != nil {
+		return err
+	}
+	defer sweepfile.Close()
+	if err := writeMetadata(sweepfile, time.Now()); err != nil {
+		return err
+	}
+	csvwriter := csv.NewWriter(sweepfile)
+	_ = csvwriter.Write([]string{parts[0], "unique-mean", "unique-std", "largest-mean", "largest-std",
+		"converged", "convergence-mean"})
+
+	// the values are counted in steps so that rounding doesn't add up
+	steps := int(math.Floor((end-start)/step+1e-9)) + 1
+sweeping:
+	for i := 0; i < steps; i++ {
+		value := strconv.FormatFloat(math.Round((start+float64(i)*step)*1e9)/1e9, 'f', -1, 64)
+		if err := flag.Set(param, value); err != nil {
+			return fmt.Errorf("sweep %q cannot set %s to %s: %s", spec, parts[0], value, err)
+		}
+		params, err := simParams()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Sweeping %s = %s over %d runs\n", parts[0], value, runs)
+
+		uniques := make([]float64, runs)
+		largest := make([]float64, runs)
+		var converged, convergence int
+		for r := 0; r < runs; r++ {
+			sim, err := culture.NewSim(params, *seed+int64(r))
+			if err != nil {
+				return err
+			}
+			if err := populate(sim); err != nil {
+				return err
+			}
+			at := -1
+			err = runHeadless(ctx, sim, injected, func(t int, _ culture.StepResult) bool {
+				if at < 0 && sim.ActiveLinkCount() == 0 {
+					at = t
+				}
+				return at < 0 || !*stopOnConvergence
+			})
+			// a cancelled sweep keeps the values that completed
+			if err != nil && ctx.Err() != nil {
+				fmt.Printf("Sweep cancelled at %s = %s\n", parts[0], value)
+				break sweeping
+			}
+			if err != nil {
+				return err
+			}
+			uniques[r] = float64(sim.DistinctCultures())
+			largest[r] = sim.LargestDomainFraction()
+			if at >= 0 {
+				converged++
+				convergence += at
+			}
+		}
+
+		uniqueMean, uniqueStd := meanStd(uniques)
+		largestMean, largestStd := meanStd(largest)
+		convergenceMean := "-1"
+		if converged > 0 {
+			convergenceMean = strconv.FormatFloat(float64(convergence)/float64(converged), 'f', -1, 64)
+		}
+		_ = csvwriter.Write([]string{value,
+			strconv.FormatFloat(uniqueMean, 'f', -1, 64), strconv.FormatFloat(uniqueStd, 'f', -1, 64),
+			strconv.FormatFloat(largestMean, 'f', -1, 64), strconv.FormatFloat(largestStd, 'f', -1, 64),
+			strconv.Itoa(converged), convergenceMean})
+	}
+	csvwriter.Flush()
+	fmt.Printf("Sweep written to sweep-%s.csv\n", name)
+	return csvwriter.Error()
+}